@@ -1,51 +1,161 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
 	"riff-streaming-adapter/pkg/adapter"
-	"strconv"
+	"riff-streaming-adapter/pkg/config"
+	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
-	httpPort, err := mandatoryIntEnvVar("HTTP_PORT")
+	arguments := os.Args[1:]
+	validate := len(arguments) > 0 && arguments[0] == "validate"
+	if validate {
+		arguments = arguments[1:]
+	}
+	configuration, err := config.Load(arguments, os.LookupEnv, os.Stderr)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if validate {
+		fmt.Println("configuration is valid")
+		return
+	}
+	run(configuration)
+}
+
+// The configuration is assumed to be valid
+func run(configuration *config.Config) {
+	streamingAdapter := adapter.NewStreamingAdapter(time.Duration(configuration.Timeouts.Invocation))
+	streamingAdapter.Logger = logger(configuration.Logging)
+	streamingAdapter.BodySplitter = bodySplitter(configuration)
+	streamingAdapter.StreamTimeout = time.Duration(configuration.Timeouts.Stream)
+	streamingAdapter.HeartbeatInterval = time.Duration(configuration.Timeouts.SseHeartbeat)
+	streamingAdapter.ManagementPort = configuration.Listeners.ManagementPort
+	streamingAdapter.ManagementPathPrefix = strings.TrimSuffix(configuration.Listeners.ManagementPathPrefix, "/")
+	streamingAdapter.WebSocketOrigins = configuration.Listeners.WebSocketOrigins
+	streamingAdapter.ReadinessTargets = readinessTargets(configuration.Health)
+	streamingAdapter.MaxRequestBodySize = configuration.Limits.MaxRequestBodyBytes
+	streamingAdapter.MaxHeaderBytes = configuration.Limits.MaxHeaderBytes
+	streamingAdapter.MaxFrameSize = configuration.Limits.MaxFrameBytes
+	tlsConfig, err := configuration.Tls.Load()
 	if err != nil {
 		panic(err)
 	}
-	httpTimeout, err := mandatoryIntEnvVar("HTTP_TIMEOUT_MILLISECONDS")
+	streamingAdapter.TlsConfig = tlsConfig
+	if configuration.Tracing.Endpoint != "" {
+		exporter, err := adapter.NewOtlpExporter(configuration.Tracing.Endpoint, configuration.Tracing.ServiceName)
+		if err != nil {
+			panic(err)
+		}
+		defer logClose(exporter)
+		exporter.Logger = streamingAdapter.Logger
+		streamingAdapter.Tracer = &adapter.Tracer{Exporter: exporter}
+	}
+	routingResolver := targetResolver(configuration.Routing, streamingAdapter.Logger)
+	if closeable, ok := routingResolver.(io.Closer); ok {
+		defer logClose(closeable)
+	}
+	resolver, err := configuration.Routing.Policy.Apply(routingResolver, configuration.Routing.Knative.ClusterDomain)
 	if err != nil {
 		panic(err)
 	}
-	timeout := time.Duration(int64(time.Millisecond) * int64(httpTimeout))
-	streamingAdapter := adapter.NewStreamingAdapter(timeout)
+	connectionPool := adapter.NewConnectionPool(resolver, time.Duration(configuration.Timeouts.PoolIdle))
+	defer logClose(connectionPool)
+	streamingAdapter.ServiceResolver = connectionPool
+	httpPort := configuration.Listeners.HttpPort
 	err = streamingAdapter.Start(httpPort)
 	if err != nil {
 		panic(err)
 	}
-	defer logClose(streamingAdapter)
+	streamingAdapter.Logger.Info("listening", "port", httpPort, "tls", tlsConfig != nil)
 
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	<-stop
+	gracePeriod := time.Duration(configuration.Timeouts.ShutdownGracePeriod)
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	streamingAdapter.Logger.Info("shutting down", "gracePeriod", gracePeriod)
+	if err := streamingAdapter.Shutdown(ctx); err != nil {
+		streamingAdapter.Logger.Warn("invocations cancelled after the grace period", "gracePeriod", gracePeriod, "error", err)
+	}
 }
 
-func mandatoryIntEnvVar(name string) (int, error) {
-	result, err := mandatoryStringEnvVar(name)
-	if err != nil {
-		return -1, err
+func targetResolver(routing config.Routing, logger *adapter.Logger) adapter.TargetResolver {
+	switch routing.Resolver {
+	case config.KnativeResolver:
+		return &adapter.KnativeServiceResolver{ClusterDomain: routing.Knative.ClusterDomain}
+	case config.KnativeHostResolver:
+		resolver, err := adapter.NewKnativeHostResolver(routing.Knative.DomainTemplate, routing.Knative.Domain, routing.Knative.ClusterDomain)
+		if err != nil {
+			panic(err)
+		}
+		return resolver
+	case config.RoutesResolver:
+		resolver, err := adapter.NewRoutingResolver(routing.AdapterRoutes())
+		if err != nil {
+			panic(err)
+		}
+		return resolver
+	case config.RegistryResolver:
+		resolver, err := adapter.NewRegistryResolver(routing.Registry.File, time.Duration(routing.Registry.ReloadInterval))
+		if err != nil {
+			panic(err)
+		}
+		resolver.Logger = logger
+		return resolver
+	case config.BalancingResolver:
+		strategy, err := adapter.ParseBalancingStrategy(routing.Balancing.Strategy)
+		if err != nil {
+			panic(err)
+		}
+		resolver := adapter.NewBalancingResolver(strategy)
+		resolver.SrvTtl = time.Duration(routing.Balancing.SrvTtl)
+		resolver.SrvDomain = routing.Balancing.SrvDomain
+		if resolver.SrvDomain == "" {
+			resolver.SrvDomain = routing.Knative.ClusterDomain
+		}
+		return resolver
+	}
+	return &adapter.PassthroughResolver{}
+}
+
+// HTTP request bodies are sent as a single frame, unless a chunk size or a delimiter is configured
+func bodySplitter(configuration *config.Config) adapter.BodySplitter {
+	if configuration.Framing.ChunkSize > 0 {
+		return &adapter.ChunkSplitter{Size: configuration.Framing.ChunkSize}
+	}
+	if configuration.Framing.Delimiter != "" {
+		return &adapter.DelimiterSplitter{Delimiter: []byte(configuration.Framing.Delimiter), MaxFrameSize: configuration.Limits.MaxFrameBytes}
 	}
-	return strconv.Atoi(result)
+	return adapter.WholeBodySplitter{}
+}
+
+// Logs are written to stderr
+func logger(logging config.Logging) *adapter.Logger {
+	level, _ := adapter.ParseLevel(logging.Level)
+	format, _ := adapter.ParseLogFormat(logging.Format)
+	return adapter.NewLogger(os.Stderr, level, format)
 }
 
-func mandatoryStringEnvVar(name string) (string, error) {
-	result, found := os.LookupEnv(name)
-	if !found {
-		return "", fmt.Errorf("envvar %s not found", name)
+func readinessTargets(health config.Health) []adapter.Target {
+	var targets []adapter.Target
+	for _, address := range health.ReadinessTargets {
+		targets = append(targets, adapter.Target{Address: strings.TrimSpace(address)})
 	}
-	return result, nil
+	return targets
 }
 
 func logClose(closeable io.Closer) {
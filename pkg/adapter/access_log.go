@@ -0,0 +1,67 @@
+package adapter
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Time elapsed from the beginning of the invocation to the end of its phases
+type phaseDurations map[string]time.Duration
+
+type phaseDurationsKey struct{}
+
+// Logs an entry per request served by next, once the response completes
+func (logger *Logger) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		started := time.Now()
+		phases := make(phaseDurations)
+		request = request.WithContext(context.WithValue(request.Context(), phaseDurationsKey{}, phases))
+		body := &countingReader{ReadCloser: request.Body}
+		request.Body = body
+		recorder := &recordingResponseWriter{ResponseWriter: responseWriter}
+		// handlers abort by panicking, see abortIfCommitted
+		defer func() {
+			label := functionLabelOf(request)
+			keyValues := []interface{}{"function", label.value}
+			if label.target != "" {
+				keyValues = append(keyValues, "target", label.target)
+			}
+			keyValues = append(keyValues,
+				"method", request.Method,
+				"path", request.URL.EscapedPath(),
+				"status", recorder.statusCode(),
+				"durationMs", milliseconds(time.Since(started)),
+			)
+			for _, phase := range []string{resolvePhase, dialPhase, firstFramePhase} {
+				if elapsed, found := phases[phase]; found {
+					keyValues = append(keyValues, phaseKeys[phase], milliseconds(elapsed))
+				}
+			}
+			keyValues = append(keyValues, "bytesIn", atomic.LoadInt64(&body.size), "bytesOut", recorder.size)
+			if requestId := request.Header.Get(RequestIdHeader); requestId != "" {
+				keyValues = append(keyValues, "requestId", requestId)
+			}
+			logger.Info("invocation", keyValues...)
+		}()
+		next.ServeHTTP(recorder, request)
+	})
+}
+
+var phaseKeys = map[string]string{
+	resolvePhase:    "resolveMs",
+	dialPhase:       "dialMs",
+	firstFramePhase: "firstFrameMs",
+}
+
+// Records the end of the phase in the access log entry of the request, if logged
+func recordPhase(request *http.Request, phase string, elapsed time.Duration) {
+	if phases, ok := request.Context().Value(phaseDurationsKey{}).(phaseDurations); ok {
+		phases[phase] = elapsed
+	}
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
@@ -0,0 +1,224 @@
+package adapter
+
+import (
+	"fmt"
+	"google.golang.org/grpc"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSrvTtl = 30 * time.Second
+	// count of the SRV names whose records are cached, beyond which cached records are evicted
+	maxSrvRecords = 1024
+)
+
+// How BalancingResolver picks one of the addresses of a function
+type BalancingStrategy string
+
+const (
+	// picks addresses in turn
+	RoundRobin BalancingStrategy = "round-robin"
+	// picks the address with the fewest invocations in progress
+	LeastOutstanding BalancingStrategy = "least-outstanding"
+	// picks the address with the fewest invocations in progress among two picked at random
+	PowerOfTwoChoices BalancingStrategy = "power-of-two-choices"
+)
+
+func ParseBalancingStrategy(value string) (BalancingStrategy, error) {
+	switch strategy := BalancingStrategy(strings.ToLower(value)); strategy {
+	case RoundRobin, LeastOutstanding, PowerOfTwoChoices:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("%q is invalid: expected strategy to be one of %s, %s, %s", value, RoundRobin, LeastOutstanding, PowerOfTwoChoices)
+}
+
+// Balances invocations across the addresses of a function, named in the X-Riff header either as a comma-separated
+// list of addresses, e.g. "10.0.0.1:8081,10.0.0.2:8081", or as a DNS SRV name, e.g.
+// "_grpc._tcp.square.default.svc.cluster.local", whose targets of the lowest priority are the addresses.
+// SRV names must belong to the SRV domain, so that clients cannot have any name looked up and cached.
+// Invocations are in progress until their HTTP request completes. Every address gets its own pooled connection.
+type BalancingResolver struct {
+	// looks SRV records up, defaults to net.DefaultResolver
+	Lookup *net.Resolver
+	// how long SRV records are cached, defaults to 30 seconds
+	SrvTtl time.Duration
+	// domain SRV names must belong to, defaults to "cluster.local"
+	SrvDomain string
+	strategy  BalancingStrategy
+	mutex     sync.Mutex
+	next      uint64
+	random    *rand.Rand
+	// count of the invocations in progress, by address, without idle addresses
+	outstanding map[string]int
+	srvRecords  map[string]srvRecords
+}
+
+type srvRecords struct {
+	addresses []string
+	expires   time.Time
+}
+
+func NewBalancingResolver(strategy BalancingStrategy) *BalancingResolver {
+	return &BalancingResolver{
+		strategy:    strategy,
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		outstanding: make(map[string]int),
+		srvRecords:  make(map[string]srvRecords),
+	}
+}
+
+func (resolver *BalancingResolver) Resolve(request *http.Request) (*grpc.ClientConn, error) {
+	return dialTarget(resolver, request)
+}
+
+func (resolver *BalancingResolver) ResolveTarget(request *http.Request) (Target, error) {
+	name, err := functionName(request)
+	if err != nil {
+		return Target{}, err
+	}
+	addresses, err := resolver.addresses(request, name)
+	if err != nil {
+		return Target{}, err
+	}
+	address := resolver.pick(addresses)
+	// requests without context, such as those of tests, are never done
+	if done := request.Context().Done(); done != nil {
+		go func() {
+			<-done
+			resolver.complete(address)
+		}()
+	} else {
+		resolver.complete(address)
+	}
+	return Target{Address: address, Authority: request.Header.Get("X-Riff-Authority")}, nil
+}
+
+func (resolver *BalancingResolver) addresses(request *http.Request, name string) ([]string, error) {
+	if strings.HasPrefix(name, "_") {
+		domain := resolver.srvDomain()
+		if !strings.HasSuffix(strings.ToLower(strings.TrimSuffix(name, ".")), "."+domain) {
+			return nil, invalidFunctionName(fmt.Sprintf("%q is invalid: expected SRV name to belong to %s", name, domain))
+		}
+		addresses, err := resolver.lookupSrv(request, name)
+		if err == nil {
+			matchFunction(request, name)
+		}
+		return addresses, err
+	}
+	var addresses []string
+	for _, address := range strings.Split(name, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, invalidFunctionName(fmt.Sprintf("%q is invalid: expected a comma-separated list of addresses, or a SRV name", name))
+	}
+	return addresses, nil
+}
+
+func (resolver *BalancingResolver) srvDomain() string {
+	if resolver.SrvDomain == "" {
+		return defaultClusterDomain
+	}
+	return strings.ToLower(strings.Trim(resolver.SrvDomain, "."))
+}
+
+// Former records are used while lookups fail
+func (resolver *BalancingResolver) lookupSrv(request *http.Request, name string) ([]string, error) {
+	now := time.Now()
+	resolver.mutex.Lock()
+	cached, found := resolver.srvRecords[name]
+	resolver.mutex.Unlock()
+	if found && now.Before(cached.expires) {
+		return cached.addresses, nil
+	}
+	lookup := resolver.Lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver
+	}
+	_, records, err := lookup.LookupSRV(request.Context(), "", "", name)
+	if err != nil || len(records) == 0 {
+		if found {
+			return cached.addresses, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("no SRV record found for %s", name)
+		}
+		return nil, err
+	}
+	var addresses []string
+	// sorted by priority
+	for _, record := range records {
+		if record.Priority != records[0].Priority {
+			break
+		}
+		addresses = append(addresses, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+	}
+	ttl := resolver.SrvTtl
+	if ttl <= 0 {
+		ttl = defaultSrvTtl
+	}
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	for cachedName, records := range resolver.srvRecords {
+		if now.After(records.expires) {
+			delete(resolver.srvRecords, cachedName)
+		}
+	}
+	// any of the records, as they are all still fresh
+	for cachedName := range resolver.srvRecords {
+		if len(resolver.srvRecords) < maxSrvRecords {
+			break
+		}
+		delete(resolver.srvRecords, cachedName)
+	}
+	resolver.srvRecords[name] = srvRecords{addresses: addresses, expires: now.Add(ttl)}
+	return addresses, nil
+}
+
+// Picks one of the addresses, and counts it as having one more invocation in progress
+func (resolver *BalancingResolver) pick(addresses []string) string {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	start := int(resolver.next % uint64(len(addresses)))
+	resolver.next++
+	address := addresses[start]
+	switch resolver.strategy {
+	case LeastOutstanding:
+		// starting from the next address in turn, so that ties are balanced
+		for i := 1; i < len(addresses); i++ {
+			candidate := addresses[(start+i)%len(addresses)]
+			if resolver.outstanding[candidate] < resolver.outstanding[address] {
+				address = candidate
+			}
+		}
+	case PowerOfTwoChoices:
+		if len(addresses) > 1 {
+			first := resolver.random.Intn(len(addresses))
+			second := (first + 1 + resolver.random.Intn(len(addresses)-1)) % len(addresses)
+			address = addresses[first]
+			if resolver.outstanding[addresses[second]] < resolver.outstanding[address] {
+				address = addresses[second]
+			}
+		}
+	}
+	resolver.outstanding[address]++
+	return address
+}
+
+func (resolver *BalancingResolver) complete(address string) {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	if resolver.outstanding[address] <= 1 {
+		delete(resolver.outstanding, address)
+	} else {
+		resolver.outstanding[address]--
+	}
+}
@@ -0,0 +1,194 @@
+package adapter_test
+
+import (
+	"context"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"net"
+	"net/http"
+	"riff-streaming-adapter/pkg/adapter"
+	"time"
+)
+
+var _ = Describe("Balancing resolver", func() {
+
+	// completes as soon as the address is resolved
+	request := func(name string) *http.Request {
+		return &http.Request{Header: http.Header{"X-Riff": {name}, "X-Riff-Authority": {"square.example.com"}}}
+	}
+
+	// in progress until cancelled
+	pendingRequest := func(name string) (*http.Request, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		return request(name).WithContext(ctx), cancel
+	}
+
+	resolveAddress := func(resolver *adapter.BalancingResolver, request *http.Request) string {
+		target, err := resolver.ResolveTarget(request)
+		Expect(err).NotTo(HaveOccurred())
+		return target.Address
+	}
+
+	It("picks addresses in turn", func() {
+		resolver := adapter.NewBalancingResolver(adapter.RoundRobin)
+
+		Expect(resolver.ResolveTarget(request("10.0.0.1:8081, 10.0.0.2:8081"))).To(Equal(adapter.Target{Address: "10.0.0.1:8081", Authority: "square.example.com"}))
+		Expect(resolveAddress(resolver, request("10.0.0.1:8081, 10.0.0.2:8081"))).To(Equal("10.0.0.2:8081"))
+		Expect(resolveAddress(resolver, request("10.0.0.1:8081, 10.0.0.2:8081"))).To(Equal("10.0.0.1:8081"))
+	})
+
+	It("picks the address with the fewest invocations in progress", func() {
+		resolver := adapter.NewBalancingResolver(adapter.LeastOutstanding)
+		pending, cancel := pendingRequest("10.0.0.1:8081,10.0.0.2:8081")
+
+		Expect(resolveAddress(resolver, pending)).To(Equal("10.0.0.1:8081"))
+		Expect(resolveAddress(resolver, request("10.0.0.1:8081,10.0.0.2:8081"))).To(Equal("10.0.0.2:8081"))
+		Expect(resolveAddress(resolver, request("10.0.0.1:8081,10.0.0.2:8081"))).To(Equal("10.0.0.2:8081"))
+
+		cancel()
+
+		Eventually(func() string {
+			return resolveAddress(resolver, request("10.0.0.1:8081,10.0.0.2:8081"))
+		}).Should(Equal("10.0.0.1:8081"))
+	})
+
+	It("picks the less busy of two addresses picked at random", func() {
+		resolver := adapter.NewBalancingResolver(adapter.PowerOfTwoChoices)
+		pending, cancel := pendingRequest("10.0.0.1:8081,10.0.0.2:8081")
+		defer cancel()
+		busyAddress := resolveAddress(resolver, pending)
+
+		for i := 0; i < 10; i++ {
+			Expect(resolveAddress(resolver, request("10.0.0.1:8081,10.0.0.2:8081"))).NotTo(Equal(busyAddress))
+		}
+	})
+
+	It("fails to resolve empty address lists", func() {
+		_, err := adapter.NewBalancingResolver(adapter.RoundRobin).ResolveTarget(request(" , "))
+
+		Expect(err).To(MatchError(`" , " is invalid: expected a comma-separated list of addresses, or a SRV name`))
+		Expect(err.(*adapter.Problem).Status).To(Equal(400))
+	})
+
+	DescribeTable("parses strategies",
+		func(value string, expectedStrategy adapter.BalancingStrategy) {
+			Expect(adapter.ParseBalancingStrategy(value)).To(Equal(expectedStrategy))
+		},
+		Entry("round-robin", "round-robin", adapter.RoundRobin),
+		Entry("least-outstanding", "Least-Outstanding", adapter.LeastOutstanding),
+		Entry("power-of-two-choices", "power-of-two-choices", adapter.PowerOfTwoChoices),
+	)
+
+	It("rejects unknown strategies", func() {
+		_, err := adapter.ParseBalancingStrategy("random")
+
+		Expect(err).To(MatchError(`"random" is invalid: expected strategy to be one of round-robin, least-outstanding, power-of-two-choices`))
+	})
+
+	Context("with SRV records", func() {
+
+		const srvName = "_grpc._tcp.square.default.svc.cluster.local"
+
+		var (
+			dns      *dnsServer
+			resolver *adapter.BalancingResolver
+		)
+
+		BeforeEach(func() {
+			dns = NewDnsServer()
+			dns.SetRecords(srvName,
+				net.SRV{Target: "square-1.example.com.", Port: 8081, Priority: 10, Weight: 50},
+				net.SRV{Target: "square-2.example.com.", Port: 8081, Priority: 10, Weight: 50},
+				net.SRV{Target: "square-backup.example.com.", Port: 8081, Priority: 20, Weight: 100},
+			)
+			resolver = adapter.NewBalancingResolver(adapter.RoundRobin)
+			resolver.Lookup = dns.Resolver()
+		})
+
+		AfterEach(func() {
+			assertClose(dns)
+		})
+
+		It("balances across the targets of the lowest priority", func() {
+			addresses := map[string]int{}
+			for i := 0; i < 4; i++ {
+				addresses[resolveAddress(resolver, request(srvName))]++
+			}
+
+			Expect(addresses).To(Equal(map[string]int{"square-1.example.com:8081": 2, "square-2.example.com:8081": 2}))
+		})
+
+		It("caches records", func() {
+			resolveAddress(resolver, request(srvName))
+			resolveAddress(resolver, request(srvName))
+
+			Expect(dns.Queries(srvName)).To(Equal(1))
+		})
+
+		It("looks records up again once expired, keeping the former ones while lookups fail", func() {
+			resolver.SrvTtl = time.Millisecond
+			resolveAddress(resolver, request(srvName))
+			time.Sleep(2 * time.Millisecond)
+			dns.SetRecords(srvName, net.SRV{Target: "square-3.example.com.", Port: 8082, Priority: 10})
+
+			Expect(resolveAddress(resolver, request(srvName))).To(Equal("square-3.example.com:8082"))
+
+			dns.RemoveRecords(srvName)
+			time.Sleep(2 * time.Millisecond)
+
+			Expect(resolveAddress(resolver, request(srvName))).To(Equal("square-3.example.com:8082"))
+		})
+
+		It("rejects names outside of the SRV domain, without looking them up", func() {
+			_, err := resolver.ResolveTarget(request("_grpc._tcp.square.example.com"))
+
+			Expect(err).To(MatchError(`"_grpc._tcp.square.example.com" is invalid: expected SRV name to belong to cluster.local`))
+			Expect(err.(*adapter.Problem).Status).To(Equal(400))
+			Expect(dns.Queries("_grpc._tcp.square.example.com")).To(Equal(0))
+		})
+
+		It("resolves names of the configured SRV domain", func() {
+			dns.SetRecords("_grpc._tcp.square.example.com", net.SRV{Target: "square-1.example.com.", Port: 8081, Priority: 10})
+			resolver.SrvDomain = "example.com."
+
+			Expect(resolveAddress(resolver, request("_grpc._tcp.square.example.com"))).To(Equal("square-1.example.com:8081"))
+			_, err := resolver.ResolveTarget(request(srvName))
+			Expect(err).To(MatchError(fmt.Sprintf("%q is invalid: expected SRV name to belong to example.com", srvName)))
+		})
+
+		It("fails to resolve unknown names", func() {
+			_, err := resolver.ResolveTarget(request("_grpc._tcp.cube.default.svc.cluster.local"))
+
+			Expect(err).To(MatchError(ContainSubstring("no such host")))
+		})
+
+		It("balances invocations end to end", func() {
+			firstConnection, firstAddress := openGrpcConnection(NewFrenchizerServer())
+			defer assertClose(firstConnection)
+			secondConnection, secondAddress := openGrpcConnection(NewFrenchizerServer())
+			defer assertClose(secondConnection)
+			records := []net.SRV{}
+			for _, address := range []string{firstAddress, secondAddress} {
+				_, port, err := net.SplitHostPort(address)
+				Expect(err).NotTo(HaveOccurred())
+				var portNumber uint16
+				_, err = fmt.Sscan(port, &portNumber)
+				Expect(err).NotTo(HaveOccurred())
+				records = append(records, net.SRV{Target: "localhost.", Port: portNumber, Priority: 10})
+			}
+			dns.SetRecords(srvName, records...)
+			pool, adapterAddress, stop := startPooledAdapter(resolver)
+			defer stop()
+
+			for i := 0; i < 2; i++ {
+				response, err := http.DefaultClient.Do(post(adapterAddress, map[string]string{"X-Riff": srvName, "Accept": "text/plain"}, "1"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(asString(response.Body)).To(Equal("un"))
+			}
+
+			Expect(pool.Stats().Dials).To(Equal(uint64(2)))
+		})
+	})
+})
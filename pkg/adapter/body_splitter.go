@@ -0,0 +1,124 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	defaultMaxFrameSize = 1024 * 1024
+	defaultChunkSize    = 64 * 1024
+)
+
+// Strategy splitting an HTTP request body into the payloads of successive Next signals
+type BodySplitter interface {
+	// Split reads the body incrementally and calls emit for every payload, in order.
+	// Errors returned by emit abort the split and are returned as is.
+	Split(body io.Reader, emit func(payload []byte) error) error
+}
+
+// Buffers the whole body into a single payload
+type WholeBodySplitter struct{}
+
+func (WholeBodySplitter) Split(body io.Reader, emit func([]byte) error) error {
+	payload, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return emit(payload)
+}
+
+// Splits the body into payloads of at most Size bytes (64KiB if unset)
+type ChunkSplitter struct {
+	Size int
+}
+
+func (splitter *ChunkSplitter) Split(body io.Reader, emit func([]byte) error) error {
+	size := splitter.Size
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+	buffer := make([]byte, size)
+	for {
+		read, err := io.ReadFull(body, buffer)
+		if read > 0 {
+			if err := emit(copyOf(buffer[:read])); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Splits the body around Delimiter, which is excluded from the resulting payloads.
+// Payloads larger than MaxFrameSize bytes (1MiB if unset) fail the split.
+type DelimiterSplitter struct {
+	Delimiter    []byte
+	MaxFrameSize int
+}
+
+func (splitter *DelimiterSplitter) Split(body io.Reader, emit func([]byte) error) error {
+	maxFrameSize := splitter.MaxFrameSize
+	if maxFrameSize <= 0 {
+		maxFrameSize = defaultMaxFrameSize
+	}
+	maxTokenSize := maxFrameSize + len(splitter.Delimiter)
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, min(4096, maxTokenSize)), maxTokenSize)
+	scanner.Split(splitter.scan)
+	for scanner.Scan() {
+		if err := emit(copyOf(scanner.Bytes())); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (splitter *DelimiterSplitter) scan(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if index := bytes.Index(data, splitter.Delimiter); index >= 0 {
+		return index + len(splitter.Delimiter), data[:index], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// Splits the body into lines, skipping blank ones and trimming line terminators (either "\n" or "\r\n").
+// Lines larger than MaxFrameSize bytes (1MiB if unset) fail the split.
+type LineSplitter struct {
+	MaxFrameSize int
+}
+
+func (splitter *LineSplitter) Split(body io.Reader, emit func([]byte) error) error {
+	delimiterSplitter := &DelimiterSplitter{Delimiter: []byte("\n"), MaxFrameSize: splitter.MaxFrameSize}
+	return delimiterSplitter.Split(body, func(line []byte) error {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(bytes.TrimSpace(line)) == 0 {
+			return nil
+		}
+		return emit(line)
+	})
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// the scanner and chunk buffers are reused across reads
+func copyOf(payload []byte) []byte {
+	return append([]byte(nil), payload...)
+}
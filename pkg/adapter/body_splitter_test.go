@@ -0,0 +1,80 @@
+package adapter_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"riff-streaming-adapter/pkg/adapter"
+	"strings"
+)
+
+var _ = Describe("Body splitter", func() {
+
+	It("emits the whole body as a single payload", func() {
+		payloads, err := split(adapter.WholeBodySplitter{}, "one\ntwo")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payloads).To(Equal([]string{"one\ntwo"}))
+	})
+
+	It("emits fixed-size chunks", func() {
+		payloads, err := split(&adapter.ChunkSplitter{Size: 3}, "abcdefgh")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payloads).To(Equal([]string{"abc", "def", "gh"}))
+	})
+
+	It("emits chunks of 64KiB when the chunk size is unset", func() {
+		payloads, err := split(&adapter.ChunkSplitter{}, strings.Repeat("a", 64*1024+1))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payloads).To(Equal([]string{strings.Repeat("a", 64*1024), "a"}))
+	})
+
+	It("emits nothing for empty bodies when chunking", func() {
+		payloads, err := split(&adapter.ChunkSplitter{Size: 3}, "")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payloads).To(BeEmpty())
+	})
+
+	It("emits delimited payloads without their delimiter", func() {
+		payloads, err := split(&adapter.DelimiterSplitter{Delimiter: []byte("--")}, "one--two----three")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payloads).To(Equal([]string{"one", "two", "", "three"}))
+	})
+
+	It("fails on delimited payloads exceeding the maximum frame size", func() {
+		_, err := split(&adapter.DelimiterSplitter{Delimiter: []byte("\n"), MaxFrameSize: 2}, "one\ntwo")
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("emits non-blank lines without their terminator", func() {
+		payloads, err := split(&adapter.LineSplitter{}, "{\"a\":1}\r\n\n  \n{\"b\":2}\n")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payloads).To(Equal([]string{`{"a":1}`, `{"b":2}`}))
+	})
+
+	It("stops at the first emission error", func() {
+		count := 0
+		err := (&adapter.ChunkSplitter{Size: 1}).Split(strings.NewReader("abc"), func([]byte) error {
+			count++
+			return fmt.Errorf("nope")
+		})
+
+		Expect(err).To(MatchError("nope"))
+		Expect(count).To(Equal(1))
+	})
+})
+
+func split(splitter adapter.BodySplitter, body string) ([]string, error) {
+	var result []string
+	err := splitter.Split(strings.NewReader(body), func(payload []byte) error {
+		result = append(result, string(payload))
+		return nil
+	})
+	return result, err
+}
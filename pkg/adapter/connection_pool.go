@@ -0,0 +1,190 @@
+package adapter
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultIdleTimeout = 5 * time.Minute
+
+// ServiceResolver sharing a single connection per target across invocations.
+// Connections are closed once idle for more than the idle timeout, or as soon as they fail or shut down.
+type ConnectionPool struct {
+	resolver    TargetResolver
+	idleTimeout time.Duration
+	mutex       sync.Mutex
+	// connections of the targets, those evicted while in use are only tracked by allConnections
+	connections    map[Target]*pooledConnection
+	allConnections map[*grpc.ClientConn]*pooledConnection
+	dials          uint64
+	hits           uint64
+	evictions      uint64
+	ctx            context.Context
+	cancel         context.CancelFunc
+}
+
+type pooledConnection struct {
+	target     Target
+	connection *grpc.ClientConn
+	inUse      int
+	lastUsed   time.Time
+}
+
+// Point-in-time statistics of a ConnectionPool
+type PoolStats struct {
+	// open connections, including the evicted ones still in use
+	Connections int
+	// invocations currently using a pooled connection
+	InUse int
+	// total count of connections dialed
+	Dials uint64
+	// total count of connections reused
+	Hits uint64
+	// total count of connections evicted, either idle, failing or shut down
+	Evictions uint64
+}
+
+// Creates a pool of the connections to the targets resolved by the given resolver.
+// The idle timeout defaults to 5 minutes.
+func NewConnectionPool(resolver TargetResolver, idleTimeout time.Duration) *ConnectionPool {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := &ConnectionPool{
+		resolver:       resolver,
+		idleTimeout:    idleTimeout,
+		connections:    make(map[Target]*pooledConnection),
+		allConnections: make(map[*grpc.ClientConn]*pooledConnection),
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	go pool.evictIdleConnections()
+	return pool
+}
+
+func (pool *ConnectionPool) Resolve(request *http.Request) (*grpc.ClientConn, error) {
+	target, err := pool.resolver.ResolveTarget(request)
+	if err != nil {
+		return nil, err
+	}
+	return pool.Acquire(target)
+}
+
+// Returns the connection to the given target, dialing it if needed.
+// The connection must be handed back with Release.
+func (pool *ConnectionPool) Acquire(target Target) (*grpc.ClientConn, error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pooled, found := pool.connections[target]; found {
+		pool.hits++
+		pooled.inUse++
+		return pooled.connection, nil
+	}
+	connection, err := target.dial()
+	if err != nil {
+		return nil, err
+	}
+	pool.dials++
+	pooled := &pooledConnection{target: target, connection: connection, inUse: 1}
+	pool.connections[target] = pooled
+	pool.allConnections[connection] = pooled
+	go pool.watchState(pooled)
+	return connection, nil
+}
+
+// Hands back a connection returned by Acquire or Resolve. Unknown connections are closed.
+func (pool *ConnectionPool) Release(connection *grpc.ClientConn) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pooled, found := pool.allConnections[connection]
+	if !found {
+		_ = connection.Close()
+		return
+	}
+	pooled.inUse--
+	pooled.lastUsed = time.Now()
+	if pooled.inUse == 0 && pool.connections[pooled.target] != pooled {
+		pool.closeConnection(pooled)
+	}
+}
+
+func (pool *ConnectionPool) Stats() PoolStats {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	stats := PoolStats{
+		Connections: len(pool.allConnections),
+		Dials:       pool.dials,
+		Hits:        pool.hits,
+		Evictions:   pool.evictions,
+	}
+	for _, pooled := range pool.allConnections {
+		stats.InUse += pooled.inUse
+	}
+	return stats
+}
+
+// Closes all connections, including the ones in use
+func (pool *ConnectionPool) Close() error {
+	pool.cancel()
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for _, pooled := range pool.allConnections {
+		pool.closeConnection(pooled)
+	}
+	pool.connections = make(map[Target]*pooledConnection)
+	return nil
+}
+
+func (pool *ConnectionPool) evictIdleConnections() {
+	ticker := time.NewTicker(pool.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pool.ctx.Done():
+			return
+		case now := <-ticker.C:
+			pool.mutex.Lock()
+			for _, pooled := range pool.connections {
+				if pooled.inUse == 0 && now.Sub(pooled.lastUsed) > pool.idleTimeout {
+					pool.evict(pooled)
+				}
+			}
+			pool.mutex.Unlock()
+		}
+	}
+}
+
+// Evicts the connection as soon as it fails or shuts down, so that the next invocation dials a fresh one
+func (pool *ConnectionPool) watchState(pooled *pooledConnection) {
+	state := pooled.connection.GetState()
+	for state != connectivity.TransientFailure && state != connectivity.Shutdown {
+		if !pooled.connection.WaitForStateChange(pool.ctx, state) {
+			return
+		}
+		state = pooled.connection.GetState()
+	}
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.connections[pooled.target] == pooled {
+		pool.evict(pooled)
+	}
+}
+
+// connections in use are closed upon release
+func (pool *ConnectionPool) evict(pooled *pooledConnection) {
+	pool.evictions++
+	delete(pool.connections, pooled.target)
+	if pooled.inUse == 0 {
+		pool.closeConnection(pooled)
+	}
+}
+
+func (pool *ConnectionPool) closeConnection(pooled *pooledConnection) {
+	delete(pool.allConnections, pooled.connection)
+	_ = pooled.connection.Close()
+}
@@ -0,0 +1,101 @@
+package adapter_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"net/http"
+	"riff-streaming-adapter/pkg/adapter"
+	"time"
+)
+
+var _ = Describe("Connection pool", func() {
+
+	var (
+		grpcConnection *grpc.ClientConn
+		grpcAddress    string
+		pool           *adapter.ConnectionPool
+	)
+
+	BeforeEach(func() {
+		grpcConnection, grpcAddress = openGrpcConnection(NewFrenchizerServer())
+	})
+
+	AfterEach(func() {
+		assertClose(pool)
+		assertClose(grpcConnection)
+	})
+
+	It("reuses connections to the same target", func() {
+		pool = adapter.NewConnectionPool(&adapter.PassthroughResolver{}, time.Minute)
+
+		connection1, err := pool.Resolve(riffRequest(grpcAddress, ""))
+		Expect(err).NotTo(HaveOccurred())
+		pool.Release(connection1)
+		connection2, err := pool.Resolve(riffRequest(grpcAddress, ""))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(connection2).To(BeIdenticalTo(connection1))
+		Expect(pool.Stats()).To(Equal(adapter.PoolStats{Connections: 1, InUse: 1, Dials: 1, Hits: 1}))
+	})
+
+	It("distinguishes targets by authority", func() {
+		pool = adapter.NewConnectionPool(&adapter.PassthroughResolver{}, time.Minute)
+
+		connection1, err := pool.Resolve(riffRequest(grpcAddress, "one.example.com"))
+		Expect(err).NotTo(HaveOccurred())
+		connection2, err := pool.Resolve(riffRequest(grpcAddress, "two.example.com"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(connection2).NotTo(BeIdenticalTo(connection1))
+		Expect(pool.Stats()).To(Equal(adapter.PoolStats{Connections: 2, InUse: 2, Dials: 2}))
+	})
+
+	It("evicts idle connections only", func() {
+		pool = adapter.NewConnectionPool(&adapter.PassthroughResolver{}, 20*time.Millisecond)
+		idleConnection, err := pool.Resolve(riffRequest(grpcAddress, "idle"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = pool.Resolve(riffRequest(grpcAddress, "busy"))
+		Expect(err).NotTo(HaveOccurred())
+
+		pool.Release(idleConnection)
+
+		Eventually(pool.Stats).Should(Equal(adapter.PoolStats{Connections: 1, InUse: 1, Dials: 2, Evictions: 1}))
+	})
+
+	It("evicts failing connections", func() {
+		pool = adapter.NewConnectionPool(&adapter.PassthroughResolver{}, time.Minute)
+		unreachableAddress := fmt.Sprintf("localhost:%d", findFreePort())
+		connection, err := pool.Resolve(riffRequest(unreachableAddress, ""))
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() uint64 { return pool.Stats().Evictions }).Should(Equal(uint64(1)))
+		pool.Release(connection)
+		Expect(pool.Stats().Connections).To(Equal(0))
+		_, err = pool.Resolve(riffRequest(unreachableAddress, ""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pool.Stats().Dials).To(Equal(uint64(2)))
+	})
+
+	It("is usable by the streaming adapter", func() {
+		pool = adapter.NewConnectionPool(&adapter.PassthroughResolver{}, time.Minute)
+		port := findFreePort()
+		streamingAdapter := &adapter.StreamingAdapter{ServiceResolver: pool, Timeout: time.Second}
+		Expect(streamingAdapter.Start(port)).To(Succeed())
+		defer assertClose(streamingAdapter)
+		adapterAddress := fmt.Sprintf("http://localhost:%d", port)
+
+		for _, payload := range []string{"1", "2", "3"} {
+			response, err := http.DefaultClient.Do(post(adapterAddress, map[string]string{"Accept": "text/plain", "X-Riff": grpcAddress}, payload))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+		}
+
+		Eventually(pool.Stats).Should(Equal(adapter.PoolStats{Connections: 1, InUse: 0, Dials: 1, Hits: 2}))
+	})
+})
+
+func riffRequest(address string, authority string) *http.Request {
+	return &http.Request{Header: http.Header{"X-Riff": {address}, "X-Riff-Authority": {authority}}}
+}
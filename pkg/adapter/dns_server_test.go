@@ -0,0 +1,150 @@
+package adapter_test // visible for tests only
+
+import (
+	"context"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"strings"
+	"sync"
+)
+
+// DNS server answering SRV, A and AAAA queries over UDP with the records it is given, and with NXDOMAIN for the names
+// it has no record of. It counts the queries it receives, by name.
+type dnsServer struct {
+	connection net.PacketConn
+	mutex      sync.Mutex
+	records    map[string][]net.SRV
+	addresses  map[string][]net.IP
+	queries    map[string]int
+}
+
+func NewDnsServer() *dnsServer {
+	connection, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	server := &dnsServer{
+		connection: connection,
+		records:    make(map[string][]net.SRV),
+		addresses:  make(map[string][]net.IP),
+		queries:    make(map[string]int),
+	}
+	go server.serve()
+	return server
+}
+
+func (server *dnsServer) SetRecords(name string, records ...net.SRV) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.records[strings.TrimSuffix(name, ".")+"."] = records
+}
+
+func (server *dnsServer) SetAddresses(name string, addresses ...net.IP) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.addresses[strings.TrimSuffix(name, ".")+"."] = addresses
+}
+
+func (server *dnsServer) RemoveRecords(name string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	delete(server.records, strings.TrimSuffix(name, ".")+".")
+}
+
+func (server *dnsServer) Queries(name string) int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.queries[strings.TrimSuffix(name, ".")+"."]
+}
+
+// Resolver sending all its queries to the server
+func (server *dnsServer) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "udp", server.connection.LocalAddr().String())
+		},
+	}
+}
+
+func (server *dnsServer) Close() error {
+	return server.connection.Close()
+}
+
+func (server *dnsServer) serve() {
+	buffer := make([]byte, 512)
+	for {
+		count, address, err := server.connection.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		if response, err := server.answer(buffer[:count]); err == nil {
+			_, _ = server.connection.WriteTo(response, address)
+		}
+	}
+}
+
+func (server *dnsServer) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(question.Name.String())
+	server.mutex.Lock()
+	server.queries[name]++
+	records, found := server.records[name]
+	addresses, hasAddresses := server.addresses[name]
+	server.mutex.Unlock()
+
+	responseHeader := dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true}
+	switch {
+	case question.Type == dnsmessage.TypeSRV && !found, question.Type != dnsmessage.TypeSRV && !hasAddresses:
+		responseHeader.RCode = dnsmessage.RCodeNameError
+	}
+	builder := dnsmessage.NewBuilder(nil, responseHeader)
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(question); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if responseHeader.RCode == dnsmessage.RCodeSuccess && question.Type == dnsmessage.TypeSRV {
+		for _, record := range records {
+			target, err := dnsmessage.NewName(strings.TrimSuffix(record.Target, ".") + ".")
+			if err != nil {
+				return nil, err
+			}
+			resourceHeader := dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: 30}
+			resource := dnsmessage.SRVResource{Priority: record.Priority, Weight: record.Weight, Port: record.Port, Target: target}
+			if err := builder.SRVResource(resourceHeader, resource); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if responseHeader.RCode == dnsmessage.RCodeSuccess && question.Type != dnsmessage.TypeSRV {
+		for _, address := range addresses {
+			resourceHeader := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 30}
+			var err error
+			if ip := address.To4(); ip != nil && question.Type == dnsmessage.TypeA {
+				resource := dnsmessage.AResource{}
+				copy(resource.A[:], ip)
+				err = builder.AResource(resourceHeader, resource)
+			} else if ip == nil && question.Type == dnsmessage.TypeAAAA {
+				resource := dnsmessage.AAAAResource{}
+				copy(resource.AAAA[:], address.To16())
+				err = builder.AAAAResource(resourceHeader, resource)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return builder.Finish()
+}
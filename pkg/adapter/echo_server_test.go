@@ -0,0 +1,68 @@
+package adapter_test // visible for tests only
+
+import (
+	"google.golang.org/grpc/metadata"
+	"io"
+	"riff-streaming-adapter/streaming"
+	"sync"
+)
+
+// gRPC server that sends back the payload of every received NEXT signal as soon as it is received.
+// It records the accepted media type, the headers and the gRPC metadata of the last invocation.
+type echoServer struct {
+	mutex    sync.Mutex
+	accept   string
+	headers  []map[string]string
+	metadata metadata.MD
+}
+
+func NewEchoServer() *echoServer {
+	return &echoServer{}
+}
+
+func (echo *echoServer) Invoke(server streaming.Riff_InvokeServer) error {
+	echo.mutex.Lock()
+	echo.headers = nil
+	echo.metadata, _ = metadata.FromIncomingContext(server.Context())
+	echo.mutex.Unlock()
+	for {
+		signal, err := server.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if value := signal.GetStart(); value != nil {
+			echo.mutex.Lock()
+			echo.accept = value.Accept
+			echo.mutex.Unlock()
+		}
+		if value := signal.GetNext(); value != nil {
+			echo.mutex.Lock()
+			echo.headers = append(echo.headers, value.Headers)
+			echo.mutex.Unlock()
+			if err := server.Send(nextSignal(string(value.Payload))); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (echo *echoServer) Accept() string {
+	echo.mutex.Lock()
+	defer echo.mutex.Unlock()
+	return echo.accept
+}
+
+func (echo *echoServer) Headers() []map[string]string {
+	echo.mutex.Lock()
+	defer echo.mutex.Unlock()
+	return echo.headers
+}
+
+func (echo *echoServer) Metadata() metadata.MD {
+	echo.mutex.Lock()
+	defer echo.mutex.Unlock()
+	return echo.metadata
+}
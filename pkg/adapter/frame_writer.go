@@ -0,0 +1,223 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"riff-streaming-adapter/streaming"
+	"strconv"
+	"strings"
+)
+
+const (
+	eventStreamMediaType = "text/event-stream"
+	ndjsonMediaType      = "application/x-ndjson"
+	// Next header holding the optional type of the corresponding server-sent event
+	SseEventHeader = "Sse-Event"
+	// Next header holding the optional ID of the corresponding server-sent event
+	SseIdHeader = "Sse-Id"
+)
+
+// Renders the Next frames emitted by the function to the HTTP response
+type frameWriter interface {
+	write(next *streaming.Next) error
+	// called once the function completes the stream
+	close()
+	// tells whether the response status has been sent
+	isCommitted() bool
+}
+
+// Writes every Next frame emitted by the function to the HTTP response as soon as it is received,
+// relying on chunked transfer encoding. The first frame commits the response status.
+type chunkedFrameWriter struct {
+	responseWriter http.ResponseWriter
+	committed      bool
+}
+
+func (writer *chunkedFrameWriter) write(next *streaming.Next) error {
+	if !writer.committed {
+		if err := writer.commit(next.Headers); err != nil {
+			return err
+		}
+	}
+	if _, err := writer.responseWriter.Write(next.Payload); err != nil {
+		return err
+	}
+	flush(writer.responseWriter)
+	return nil
+}
+
+// the function may complete the stream without emitting anything
+func (writer *chunkedFrameWriter) close() {
+	if !writer.committed {
+		_ = writer.commit(nil)
+	}
+}
+
+func (writer *chunkedFrameWriter) isCommitted() bool {
+	return writer.committed
+}
+
+func (writer *chunkedFrameWriter) commit(headers map[string]string) error {
+	if err := writeHead(writer.responseWriter, headers, ""); err != nil {
+		return err
+	}
+	writer.committed = true
+	return nil
+}
+
+// Writes the payload of every Next frame emitted by the function as a line of newline-delimited JSON
+type ndjsonFrameWriter struct {
+	responseWriter http.ResponseWriter
+	committed      bool
+}
+
+// Payloads spanning several lines are compacted to a single one, payloads that are not JSON fail the invocation
+func (writer *ndjsonFrameWriter) write(next *streaming.Next) error {
+	line := &bytes.Buffer{}
+	if err := json.Compact(line, next.Payload); err != nil {
+		return invalidFunctionResponse(fmt.Sprintf("invalid JSON payload: %v", err))
+	}
+	if err := writer.commitOnce(next.Headers); err != nil {
+		return err
+	}
+	line.WriteByte('\n')
+	if _, err := writer.responseWriter.Write(line.Bytes()); err != nil {
+		return err
+	}
+	flush(writer.responseWriter)
+	return nil
+}
+
+func (writer *ndjsonFrameWriter) close() {
+	_ = writer.commitOnce(nil)
+}
+
+func (writer *ndjsonFrameWriter) isCommitted() bool {
+	return writer.committed
+}
+
+// the function controls the response status and headers, except for the content type
+func (writer *ndjsonFrameWriter) commitOnce(headers map[string]string) error {
+	if writer.committed {
+		return nil
+	}
+	if err := writeHead(writer.responseWriter, headers, ndjsonMediaType); err != nil {
+		return err
+	}
+	writer.committed = true
+	return nil
+}
+
+// Writes every Next frame emitted by the function as a server-sent event.
+// The payload becomes the event data, the SseEventHeader and SseIdHeader headers its type and ID.
+type eventStreamWriter struct {
+	responseWriter http.ResponseWriter
+	committed      bool
+}
+
+func (writer *eventStreamWriter) write(next *streaming.Next) error {
+	writer.commitOnce()
+	var event bytes.Buffer
+	if eventType := next.Headers[SseEventHeader]; eventType != "" {
+		writeField(&event, "event", []byte(eventType))
+	}
+	if id := next.Headers[SseIdHeader]; id != "" {
+		writeField(&event, "id", []byte(id))
+	}
+	for _, line := range bytes.Split(next.Payload, []byte("\n")) {
+		writeField(&event, "data", line)
+	}
+	event.WriteString("\n")
+	return writer.send(event.Bytes())
+}
+
+// comments are ignored by clients but keep the connection busy for intermediaries
+func (writer *eventStreamWriter) heartbeat() error {
+	writer.commitOnce()
+	return writer.send([]byte(": heartbeat\n\n"))
+}
+
+func (writer *eventStreamWriter) close() {
+	writer.commitOnce()
+}
+
+func (writer *eventStreamWriter) isCommitted() bool {
+	return writer.committed
+}
+
+func (writer *eventStreamWriter) commitOnce() {
+	if writer.committed {
+		return
+	}
+	writer.committed = true
+	headers := writer.responseWriter.Header()
+	headers.Set("Content-Type", eventStreamMediaType)
+	headers.Set("Cache-Control", "no-cache")
+	writer.responseWriter.WriteHeader(200)
+}
+
+func (writer *eventStreamWriter) send(event []byte) error {
+	if _, err := writer.responseWriter.Write(event); err != nil {
+		return err
+	}
+	flush(writer.responseWriter)
+	return nil
+}
+
+// field values cannot span several lines
+func writeField(event *bytes.Buffer, name string, value []byte) {
+	event.WriteString(name)
+	event.WriteString(": ")
+	if index := bytes.IndexAny(value, "\r\n"); index >= 0 {
+		value = value[:index]
+	}
+	event.Write(value)
+	event.WriteString("\n")
+}
+
+// Writes the response status and headers set by the function in the headers of its first frame.
+// The status defaults to 200 when StatusHeader is absent, an invalid one is reported as a problem and nothing is written.
+// The function content type is overridden by contentType, if set.
+func writeHead(responseWriter http.ResponseWriter, headers map[string]string, contentType string) error {
+	status := 200
+	for key, value := range headers {
+		if http.CanonicalHeaderKey(key) != StatusHeader {
+			continue
+		}
+		parsedStatus, err := strconv.Atoi(value)
+		if err != nil || parsedStatus < 200 || parsedStatus > 599 {
+			return invalidFunctionResponse(fmt.Sprintf("invalid status %q", value))
+		}
+		status = parsedStatus
+	}
+	for key, value := range headers {
+		key = http.CanonicalHeaderKey(key)
+		if key == StatusHeader || key == "Content-Length" {
+			continue
+		}
+		for _, splitValue := range strings.Split(value, HeaderValueSeparator) {
+			responseWriter.Header().Add(key, splitValue)
+		}
+	}
+	if contentType != "" {
+		responseWriter.Header().Set("Content-Type", contentType)
+	}
+	responseWriter.WriteHeader(status)
+	return nil
+}
+
+// Errors occurring once the response status is sent cannot be reported to the client anymore.
+// Aborting the handler at least makes sure the client does not mistake the truncated response for a complete one.
+func abortIfCommitted(writer frameWriter) {
+	if writer.isCommitted() {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func flush(responseWriter http.ResponseWriter) {
+	if flusher, ok := responseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,56 @@
+package adapter
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// Maps the status of a failed invocation to the closest HTTP status.
+// Unknown covers errors returned as is by functions, it is therefore considered as a gateway error.
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return 400
+	case codes.Unauthenticated:
+		return 401
+	case codes.PermissionDenied:
+		return 403
+	case codes.NotFound:
+		return 404
+	case codes.AlreadyExists, codes.Aborted:
+		return 409
+	case codes.ResourceExhausted:
+		return 429
+	case codes.Internal, codes.DataLoss:
+		return 500
+	case codes.Unimplemented:
+		return 501
+	case codes.Unavailable:
+		return 503
+	case codes.DeadlineExceeded:
+		return 504
+	default:
+		return 502
+	}
+}
+
+// Describes the status of a failed invocation: its code in the title, its message and then one line per detail in the detail
+func statusProblem(grpcStatus *status.Status) *Problem {
+	var detail strings.Builder
+	detail.WriteString(grpcStatus.Message())
+	for _, statusDetail := range grpcStatus.Details() {
+		detail.WriteString("\n")
+		if message, ok := statusDetail.(proto.Message); ok {
+			detail.WriteString(proto.CompactTextString(message))
+		} else {
+			detail.WriteString(fmt.Sprintf("%v", statusDetail))
+		}
+	}
+	return newProblem("function-failure", httpStatusFromCode(grpcStatus.Code()),
+		fmt.Sprintf("gRPC server failed with %s", grpcStatus.Code()), detail.String())
+}
@@ -0,0 +1,37 @@
+package adapter
+
+import "net/http"
+
+// Next headers are single-valued: the values of repeated HTTP headers are joined with this separator, in order,
+// both in the frames sent to the function and in the ones it emits.
+// HTTP forbids line feeds in header values, so the separator cannot be mistaken for actual content.
+const HeaderValueSeparator = "\n"
+
+// Reserved Next headers describing the HTTP request the frames originate from.
+// They are set on every frame sent to the function and override any client header of the same name.
+const (
+	// HTTP method, e.g. "POST"
+	MethodHeader = "X-Riff-Method"
+	// percent-encoded URL path, e.g. "/orders/42"
+	PathHeader = "X-Riff-Path"
+	// raw query string without the leading "?", possibly empty
+	QueryHeader = "X-Riff-Query"
+	// network address of the client (or last proxy), e.g. "10.0.0.1:51234"
+	RemoteAddrHeader = "X-Riff-Remote-Addr"
+)
+
+// Reserved Next header the function sets on its first frame to choose the HTTP response status, e.g. "201".
+// The response status defaults to 200.
+const StatusHeader = "X-Riff-Status"
+
+// Computes the headers of the Next signals sent to the function on behalf of the given request.
+// They carry the ID of the request and the trace context of the invocation, if traced.
+func nextHeaders(request *http.Request) map[string]string {
+	headers := copyRequestHeaders(request.Header, "Accept")
+	headers[MethodHeader] = request.Method
+	headers[PathHeader] = request.URL.EscapedPath()
+	headers[QueryHeader] = request.URL.RawQuery
+	headers[RemoteAddrHeader] = request.RemoteAddr
+	injectTraceHeaders(request.Context(), headers)
+	return headers
+}
@@ -0,0 +1,32 @@
+package adapter_test // visible for tests only
+
+import (
+	"encoding/json"
+	"riff-streaming-adapter/streaming"
+)
+
+// gRPC server that replies to the first received NEXT signal with a NEXT signal whose headers are the JSON object
+// carried by the received payload, and whose payload is "body".
+type headersServer struct{}
+
+func NewHeadersServer() *headersServer {
+	return &headersServer{}
+}
+
+func (*headersServer) Invoke(server streaming.Riff_InvokeServer) error {
+	for {
+		signal, err := server.Recv()
+		if err != nil {
+			return err
+		}
+		if value := signal.GetNext(); value != nil {
+			var headers map[string]string
+			if err := json.Unmarshal(value.Payload, &headers); err != nil {
+				return err
+			}
+			reply := nextSignal("body")
+			reply.GetNext().Headers = headers
+			return server.Send(reply)
+		}
+	}
+}
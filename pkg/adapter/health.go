@@ -0,0 +1,66 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+	// maximum duration of the health check of every readiness target
+	readinessCheckTimeout = time.Second
+)
+
+// The adapter is alive as long as it serves requests
+func serveLiveness(responseWriter http.ResponseWriter) {
+	_ = writeBody(responseWriter, 200, "text/plain; charset=utf-8", []byte("ok"))
+}
+
+// The adapter is ready unless it is draining or any of the readiness targets is not serving,
+// according to the gRPC health-checking protocol. Failures are listed one per line.
+func (adapter *StreamingAdapter) serveReadiness(responseWriter http.ResponseWriter, request *http.Request) {
+	if failures := adapter.readinessFailures(request.Context()); len(failures) > 0 {
+		_ = writeBody(responseWriter, 503, "text/plain; charset=utf-8", []byte(strings.Join(failures, "\n")))
+		return
+	}
+	_ = writeBody(responseWriter, 200, "text/plain; charset=utf-8", []byte("ok"))
+}
+
+func (adapter *StreamingAdapter) readinessFailures(ctx context.Context) []string {
+	if adapter.Draining() {
+		return []string{"shutting down"}
+	}
+	var failures []string
+	for _, target := range adapter.ReadinessTargets {
+		if err := checkHealth(ctx, target); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", target.Address, err))
+		}
+	}
+	return failures
+}
+
+// Asks the gRPC server for its overall health
+func checkHealth(ctx context.Context, target Target) error {
+	connection, err := target.dial()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = connection.Close()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+	response, err := grpc_health_v1.NewHealthClient(connection).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if response.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("gRPC server is %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package adapter_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"riff-streaming-adapter/pkg/adapter"
+	"time"
+)
+
+var _ = Describe("Health endpoints", func() {
+
+	var (
+		grpcConnection   *grpc.ClientConn
+		streamingAdapter *adapter.StreamingAdapter
+		adapterAddress   string
+		httpClient       *http.Client
+	)
+
+	newAdapter := func(server *tickerServer) {
+		var grpcAddress string
+		grpcConnection, grpcAddress = openGrpcConnection(server)
+		streamingAdapter = &adapter.StreamingAdapter{
+			ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+			Timeout:         time.Minute,
+		}
+		httpClient = &http.Client{}
+	}
+
+	start := func() {
+		httpPort := findFreePort()
+		Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+		adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+	}
+
+	get := func(url string) (int, string) {
+		response, err := httpClient.Get(url)
+		Expect(err).NotTo(HaveOccurred())
+		return response.StatusCode, asString(response.Body)
+	}
+
+	statusOf := func(url string) int {
+		status, _ := get(url)
+		return status
+	}
+
+	AfterEach(func() {
+		assertClose(streamingAdapter)
+		assertClose(grpcConnection)
+	})
+
+	It("serves liveness and readiness on the main port", func() {
+		newAdapter(NewTickerServer(time.Minute))
+		start()
+
+		Expect(statusOf(adapterAddress + "/healthz")).To(Equal(200))
+		Expect(statusOf(adapterAddress + "/readyz")).To(Equal(200))
+	})
+
+	It("serves health endpoints under the configured path prefix", func() {
+		newAdapter(NewTickerServer(time.Minute))
+		streamingAdapter.ManagementPathPrefix = "/_riff"
+		start()
+
+		Expect(statusOf(adapterAddress + "/_riff/healthz")).To(Equal(200))
+		Expect(statusOf(adapterAddress + "/_riff/readyz")).To(Equal(200))
+	})
+
+	It("only serves health endpoints on the management port", func() {
+		newAdapter(NewTickerServer(10 * time.Millisecond))
+		managementPort := findFreePort()
+		streamingAdapter.ManagementPort = managementPort
+		start()
+		managementAddress := fmt.Sprintf("http://localhost:%d", managementPort)
+
+		Expect(statusOf(managementAddress + "/healthz")).To(Equal(200))
+		Expect(statusOf(managementAddress + "/readyz")).To(Equal(200))
+		Expect(statusOf(managementAddress + "/functions")).To(Equal(404))
+		response, err := httpClient.Do(post(adapterAddress+"/healthz", map[string]string{"Accept": "text/plain"}, ""))
+		Expect(err).NotTo(HaveOccurred())
+		defer assertClose(response.Body)
+		Expect(bufio.NewReader(response.Body).ReadString('\n')).To(Equal("tick\n"))
+	})
+
+	It("reports the adapter as not ready while draining", func() {
+		newAdapter(NewTickerServer(10 * time.Millisecond))
+		managementPort := findFreePort()
+		streamingAdapter.ManagementPort = managementPort
+		start()
+		response, err := httpClient.Do(post(adapterAddress, map[string]string{"Accept": "text/event-stream"}, ""))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = readEvent(bufio.NewReader(response.Body))
+		Expect(err).NotTo(HaveOccurred())
+
+		result := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			result <- streamingAdapter.Shutdown(ctx)
+		}()
+
+		readiness := fmt.Sprintf("http://localhost:%d/readyz", managementPort)
+		Eventually(func() int {
+			return statusOf(readiness)
+		}).Should(Equal(503))
+		_, body := get(readiness)
+		Expect(body).To(Equal("shutting down"))
+		Expect(statusOf(fmt.Sprintf("http://localhost:%d/healthz", managementPort))).To(Equal(200))
+		assertClose(response.Body)
+		Eventually(result).Should(Receive(BeNil()))
+	})
+
+	Describe("with readiness targets", func() {
+		var (
+			healthServer *health.Server
+			grpcServer   *grpc.Server
+			healthTarget adapter.Target
+		)
+
+		BeforeEach(func() {
+			newAdapter(NewTickerServer(time.Minute))
+			healthServer = health.NewServer()
+			grpcServer = grpc.NewServer()
+			grpc_health_v1.RegisterHealthServer(grpcServer, healthServer)
+			listener := makeListener(":0")
+			go func() {
+				_ = grpcServer.Serve(listener)
+			}()
+			healthTarget = adapter.Target{Address: fmt.Sprintf("localhost:%d", portOf(listener))}
+		})
+
+		AfterEach(func() {
+			grpcServer.Stop()
+		})
+
+		It("is ready when all targets are serving", func() {
+			healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+			streamingAdapter.ReadinessTargets = []adapter.Target{healthTarget}
+			start()
+
+			Expect(statusOf(adapterAddress + "/readyz")).To(Equal(200))
+		})
+
+		It("is not ready when a target is not serving", func() {
+			healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+			streamingAdapter.ReadinessTargets = []adapter.Target{healthTarget}
+			start()
+
+			status, body := get(adapterAddress + "/readyz")
+
+			Expect(status).To(Equal(503))
+			Expect(body).To(Equal(healthTarget.Address + ": gRPC server is NOT_SERVING"))
+			Expect(statusOf(adapterAddress + "/healthz")).To(Equal(200))
+		})
+
+		It("is not ready when a target is unreachable", func() {
+			unreachableTarget := adapter.Target{Address: fmt.Sprintf("localhost:%d", findFreePort())}
+			streamingAdapter.ReadinessTargets = []adapter.Target{healthTarget, unreachableTarget}
+			start()
+
+			status, body := get(adapterAddress + "/readyz")
+
+			Expect(status).To(Equal(503))
+			Expect(body).To(HavePrefix(unreachableTarget.Address + ": "))
+		})
+	})
+})
@@ -0,0 +1,31 @@
+package adapter_test // visible for tests only
+
+import (
+	"io"
+	"riff-streaming-adapter/streaming"
+	"strings"
+)
+
+// gRPC server that collects the payloads of all received NEXT signals and, once the client is done sending,
+// replies with a single NEXT signal joining them with "|".
+type joinerServer struct{}
+
+func NewJoinerServer() *joinerServer {
+	return &joinerServer{}
+}
+
+func (*joinerServer) Invoke(server streaming.Riff_InvokeServer) error {
+	var payloads []string
+	for {
+		signal, err := server.Recv()
+		if err == io.EOF {
+			return server.Send(nextSignal(strings.Join(payloads, "|")))
+		}
+		if err != nil {
+			return err
+		}
+		if value := signal.GetNext(); value != nil {
+			payloads = append(payloads, string(value.Payload))
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package adapter
+
+import (
+	"bytes"
+	"fmt"
+	"google.golang.org/grpc"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Knative's default domain template
+const DefaultDomainTemplate = "{{.Name}}.{{.Namespace}}.{{.Domain}}"
+
+const (
+	nameMarker      = "\x00name\x00"
+	namespaceMarker = "\x00namespace\x00"
+	// DNS label, as Kubernetes service names and namespaces are
+	labelPattern = "([a-z0-9](?:[-a-z0-9]*[a-z0-9])?)"
+)
+
+// Resolves the Kubernetes service whose name and namespace are found in the Host header, according to the domain
+// template, e.g. "square.default.example.com" for service "square" of namespace "default".
+// This lets the adapter sit behind a standard Knative ingress, without clients setting any X-Riff header.
+// The authority is the host, without port.
+type KnativeHostResolver struct {
+	domainTemplate string
+	clusterDomain  string
+	hostPattern    *regexp.Regexp
+	// indexes of the name and namespace groups of hostPattern
+	nameGroup      int
+	namespaceGroup int
+}
+
+// Fields of the domain template, as defined by Knative. Annotations are always empty.
+type domainTemplateFields struct {
+	Name        string
+	Namespace   string
+	Domain      string
+	Annotations map[string]string
+}
+
+// The domain template is a Go template, e.g. "{{.Name}}-{{.Namespace}}.{{.Domain}}", defaulting to
+// DefaultDomainTemplate. It must reference both the name and the namespace. Any domain matches if domain is empty.
+// The cluster domain defaults to "cluster.local".
+func NewKnativeHostResolver(domainTemplate string, domain string, clusterDomain string) (*KnativeHostResolver, error) {
+	if domainTemplate == "" {
+		domainTemplate = DefaultDomainTemplate
+	}
+	parsedTemplate, err := template.New("domain").Option("missingkey=zero").Parse(domainTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("%q is invalid: %v", domainTemplate, err)
+	}
+	domainMarker := "\x00domain\x00"
+	var rendered bytes.Buffer
+	err = parsedTemplate.Execute(&rendered, domainTemplateFields{Name: nameMarker, Namespace: namespaceMarker, Domain: domainMarker})
+	if err != nil {
+		return nil, fmt.Errorf("%q is invalid: %v", domainTemplate, err)
+	}
+	pattern := regexp.QuoteMeta(strings.ToLower(rendered.String()))
+	if !strings.Contains(pattern, nameMarker) || !strings.Contains(pattern, namespaceMarker) {
+		return nil, fmt.Errorf("%q is invalid: expected the template to reference both {{.Name}} and {{.Namespace}}", domainTemplate)
+	}
+	resolver := &KnativeHostResolver{domainTemplate: domainTemplate, clusterDomain: clusterDomain}
+	if strings.Index(pattern, nameMarker) < strings.Index(pattern, namespaceMarker) {
+		resolver.nameGroup, resolver.namespaceGroup = 1, 2
+	} else {
+		resolver.nameGroup, resolver.namespaceGroup = 2, 1
+	}
+	// later references must match the same values, which regexp cannot express: they match any label instead
+	pattern = strings.Replace(pattern, nameMarker, labelPattern, 1)
+	pattern = strings.Replace(pattern, namespaceMarker, labelPattern, 1)
+	pattern = strings.NewReplacer(nameMarker, "[-a-z0-9]+", namespaceMarker, "[-a-z0-9]+").Replace(pattern)
+	domainPattern := ".+"
+	if domain != "" {
+		domainPattern = regexp.QuoteMeta(strings.ToLower(strings.TrimSuffix(domain, ".")))
+	}
+	pattern = strings.Replace(pattern, domainMarker, domainPattern, -1)
+	if resolver.hostPattern, err = regexp.Compile("^" + pattern + "$"); err != nil {
+		return nil, fmt.Errorf("%q is invalid: %v", domainTemplate, err)
+	}
+	return resolver, nil
+}
+
+func (resolver *KnativeHostResolver) Resolve(request *http.Request) (*grpc.ClientConn, error) {
+	return dialTarget(resolver, request)
+}
+
+func (resolver *KnativeHostResolver) ResolveTarget(request *http.Request) (Target, error) {
+	host := requestHost(request)
+	groups := resolver.hostPattern.FindStringSubmatch(host)
+	if groups == nil {
+		return Target{}, unknownHost(fmt.Sprintf("%q is invalid: expected host to follow %s", host, resolver.domainTemplate))
+	}
+	address := serviceHost(groups[resolver.nameGroup], groups[resolver.namespaceGroup], resolver.clusterDomain)
+	matchFunction(request, groups[resolver.nameGroup]+"/"+groups[resolver.namespaceGroup])
+	return Target{Address: address, Authority: host}, nil
+}
@@ -0,0 +1,67 @@
+package adapter
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Listener keeping track of its open connections by remote address, so that the reads of a request still pending
+// once its invocation is over, such as those of stalled uploads, can be interrupted.
+// Handlers have no other way to reach the connection of their requests.
+type trackingListener struct {
+	net.Listener
+	// *trackedConnection by remote address
+	connections sync.Map
+}
+
+func (listener *trackingListener) Accept() (net.Conn, error) {
+	connection, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tracked := &trackedConnection{Conn: connection, listener: listener}
+	listener.connections.Store(connection.RemoteAddr().String(), tracked)
+	return tracked, nil
+}
+
+// Fails the pending and next reads of the connection of the request, if still open
+func (listener *trackingListener) interruptReads(request *http.Request) {
+	if connection, found := listener.connections.Load(request.RemoteAddr); found {
+		_ = connection.(*trackedConnection).SetReadDeadline(time.Unix(1, 0))
+	}
+}
+
+type trackedConnection struct {
+	net.Conn
+	listener  *trackingListener
+	closeOnce sync.Once
+}
+
+func (connection *trackedConnection) Close() error {
+	connection.closeOnce.Do(func() {
+		connection.listener.connections.Delete(connection.RemoteAddr().String())
+	})
+	return connection.Conn.Close()
+}
+
+// Request body telling whether it has been read to the end, after which reads no longer block
+type trackedBody struct {
+	io.ReadCloser
+	ended int32
+}
+
+func (body *trackedBody) Read(buffer []byte) (int, error) {
+	count, err := body.ReadCloser.Read(buffer)
+	if err != nil {
+		atomic.StoreInt32(&body.ended, 1)
+	}
+	return count, err
+}
+
+func (body *trackedBody) isEnded() bool {
+	return atomic.LoadInt32(&body.ended) == 1
+}
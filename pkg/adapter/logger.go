@@ -0,0 +1,174 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < DebugLevel || level > ErrorLevel {
+		return strconv.Itoa(int(level))
+	}
+	return levelNames[level]
+}
+
+func ParseLevel(value string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(value, name) {
+			return Level(level), nil
+		}
+	}
+	return 0, fmt.Errorf("%q is invalid: expected level to be one of %s", value, strings.Join(levelNames, ", "))
+}
+
+type LogFormat string
+
+const (
+	JsonFormat   LogFormat = "json"
+	LogfmtFormat LogFormat = "logfmt"
+)
+
+func ParseLogFormat(value string) (LogFormat, error) {
+	switch format := LogFormat(strings.ToLower(value)); format {
+	case JsonFormat, LogfmtFormat:
+		return format, nil
+	}
+	return "", fmt.Errorf("%q is invalid: expected format to be one of %s, %s", value, JsonFormat, LogfmtFormat)
+}
+
+// Writes one structured entry per line, made of the time, level and message followed by key-value pairs.
+// Entries below the logger level are discarded, as are all entries of nil loggers.
+type Logger struct {
+	mutex  *sync.Mutex
+	output io.Writer
+	level  Level
+	format LogFormat
+	// key-value pairs added to every entry
+	context []interface{}
+}
+
+func NewLogger(output io.Writer, level Level, format LogFormat) *Logger {
+	return &Logger{mutex: &sync.Mutex{}, output: output, level: level, format: format}
+}
+
+// Returns a logger adding the given key-value pairs to every entry
+func (logger *Logger) With(keyValues ...interface{}) *Logger {
+	if logger == nil {
+		return nil
+	}
+	child := *logger
+	child.context = append(append([]interface{}{}, logger.context...), keyValues...)
+	return &child
+}
+
+func (logger *Logger) Debug(message string, keyValues ...interface{}) {
+	logger.log(DebugLevel, message, keyValues)
+}
+
+func (logger *Logger) Info(message string, keyValues ...interface{}) {
+	logger.log(InfoLevel, message, keyValues)
+}
+
+func (logger *Logger) Warn(message string, keyValues ...interface{}) {
+	logger.log(WarnLevel, message, keyValues)
+}
+
+func (logger *Logger) Error(message string, keyValues ...interface{}) {
+	logger.log(ErrorLevel, message, keyValues)
+}
+
+func (logger *Logger) log(level Level, message string, keyValues []interface{}) {
+	if logger == nil || level < logger.level {
+		return
+	}
+	entry := append([]interface{}{"time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", message}, logger.context...)
+	entry = append(entry, keyValues...)
+	if len(entry)%2 != 0 {
+		entry = append(entry, "(missing)")
+	}
+	var line []byte
+	if logger.format == LogfmtFormat {
+		line = encodeLogfmt(entry)
+	} else {
+		line = encodeJson(entry)
+	}
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	_, _ = logger.output.Write(line)
+}
+
+// Keys keep their order, numbers and booleans are written as is, any other value as a string
+func encodeJson(entry []interface{}) []byte {
+	var line bytes.Buffer
+	line.WriteString("{")
+	for i := 0; i < len(entry); i += 2 {
+		if i > 0 {
+			line.WriteString(",")
+		}
+		key, _ := json.Marshal(fmt.Sprint(entry[i]))
+		line.Write(key)
+		line.WriteString(":")
+		value, err := json.Marshal(logValue(entry[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(entry[i+1]))
+		}
+		line.Write(value)
+	}
+	line.WriteString("}\n")
+	return line.Bytes()
+}
+
+// Values are quoted when empty or when they contain spaces, quotes, equal signs or control characters
+func encodeLogfmt(entry []interface{}) []byte {
+	var line bytes.Buffer
+	for i := 0; i < len(entry); i += 2 {
+		if i > 0 {
+			line.WriteString(" ")
+		}
+		line.WriteString(fmt.Sprint(entry[i]))
+		line.WriteString("=")
+		value := fmt.Sprint(logValue(entry[i+1]))
+		if value == "" || strings.IndexFunc(value, needsQuoting) >= 0 {
+			value = strconv.Quote(value)
+		}
+		line.WriteString(value)
+	}
+	line.WriteString("\n")
+	return line.Bytes()
+}
+
+func needsQuoting(r rune) bool {
+	return r <= ' ' || r == '"' || r == '=' || r == '\\' || unicode.IsControl(r)
+}
+
+func logValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case nil:
+		return nil
+	case error:
+		return typedValue.Error()
+	case time.Duration:
+		return typedValue.String()
+	case fmt.Stringer:
+		return typedValue.String()
+	}
+	return value
+}
@@ -0,0 +1,142 @@
+package adapter_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"google.golang.org/grpc"
+	"net/http"
+	"riff-streaming-adapter/pkg/adapter"
+	"strings"
+	"time"
+)
+
+var _ = Describe("Logger", func() {
+
+	var output *bytes.Buffer
+
+	BeforeEach(func() {
+		output = &bytes.Buffer{}
+	})
+
+	It("writes JSON entries with their fields in order", func() {
+		logger := adapter.NewLogger(output, adapter.InfoLevel, adapter.JsonFormat)
+
+		logger.With("function", "frenchizer").Warn("error when sending", "error", errors.New("boom"), "count", 2, "elapsed", time.Second)
+
+		Expect(output.String()).To(MatchRegexp(`^\{"time":"[^"]+","level":"warn","msg":"error when sending","function":"frenchizer","error":"boom","count":2,"elapsed":"1s"\}\n$`))
+	})
+
+	It("writes logfmt entries, quoting values when needed", func() {
+		logger := adapter.NewLogger(output, adapter.DebugLevel, adapter.LogfmtFormat)
+
+		logger.Debug("invocation", "path", "/", "query", "a=b", "detail", `say "hi"`, "empty", "")
+
+		Expect(output.String()).To(MatchRegexp(`^time=\S+ level=debug msg=invocation path=/ query="a=b" detail="say \\"hi\\"" empty=""\n$`))
+	})
+
+	It("discards entries below its level", func() {
+		logger := adapter.NewLogger(output, adapter.WarnLevel, adapter.LogfmtFormat)
+
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Error("error")
+
+		Expect(strings.Count(output.String(), "\n")).To(Equal(1))
+		Expect(output.String()).To(ContainSubstring("level=error msg=error"))
+	})
+
+	It("does nothing when nil", func() {
+		var logger *adapter.Logger
+
+		Expect(func() { logger.With("key", "value").Error("error") }).NotTo(Panic())
+	})
+
+	It("parses levels and formats", func() {
+		Expect(adapter.ParseLevel("WARN")).To(Equal(adapter.WarnLevel))
+		_, err := adapter.ParseLevel("verbose")
+		Expect(err).To(MatchError(`"verbose" is invalid: expected level to be one of debug, info, warn, error`))
+		Expect(adapter.ParseLogFormat("logfmt")).To(Equal(adapter.LogfmtFormat))
+		_, err = adapter.ParseLogFormat("xml")
+		Expect(err).To(MatchError(`"xml" is invalid: expected format to be one of json, logfmt`))
+	})
+
+	Describe("access logs", func() {
+		var (
+			grpcConnection   *grpc.ClientConn
+			grpcAddress      string
+			streamingAdapter *adapter.StreamingAdapter
+			adapterAddress   string
+			logs             *gbytes.Buffer
+		)
+
+		BeforeEach(func() {
+			grpcConnection, grpcAddress = openGrpcConnection(NewFrenchizerServer())
+			logs = gbytes.NewBuffer()
+			resolver, err := adapter.NewRoutingResolver([]adapter.Route{
+				{PathPrefix: "/numbers", Target: adapter.Target{Address: grpcAddress}, Function: "frenchizer"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: resolver,
+				Timeout:         time.Minute,
+				Logger:          adapter.NewLogger(logs, adapter.InfoLevel, adapter.JsonFormat),
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+		})
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+		})
+
+		It("logs every invocation", func() {
+			response, err := http.DefaultClient.Do(post(adapterAddress+"/numbers", map[string]string{
+				"X-Request-Id": "42",
+				"Accept":       "text/plain",
+			}, "1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(asString(response.Body)).To(Equal("un"))
+
+			Eventually(logs).Should(gbytes.Say(`"msg":"invocation"`))
+			var entry map[string]interface{}
+			Expect(json.Unmarshal(bytes.TrimSpace(logs.Contents()), &entry)).To(Succeed())
+			Expect(entry).To(HaveKeyWithValue("level", "info"))
+			Expect(entry).To(HaveKeyWithValue("function", "frenchizer"))
+			Expect(entry).To(HaveKeyWithValue("target", grpcAddress))
+			Expect(entry).To(HaveKeyWithValue("method", "POST"))
+			Expect(entry).To(HaveKeyWithValue("path", "/numbers"))
+			Expect(entry).To(HaveKeyWithValue("status", BeNumerically("==", 200)))
+			Expect(entry).To(HaveKeyWithValue("bytesIn", BeNumerically("==", 1)))
+			Expect(entry).To(HaveKeyWithValue("bytesOut", BeNumerically("==", 2)))
+			Expect(entry).To(HaveKeyWithValue("requestId", "42"))
+			for _, duration := range []string{"durationMs", "resolveMs", "dialMs", "firstFrameMs"} {
+				Expect(entry).To(HaveKeyWithValue(duration, BeNumerically(">=", 0)))
+			}
+		})
+
+		It("logs failed invocations", func() {
+			response, err := http.DefaultClient.Do(post(adapterAddress+"/numbers", map[string]string{adapter.TimeoutHeader: "oops"}, "1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(400))
+			assertClose(response.Body)
+
+			Eventually(logs).Should(gbytes.Say(fmt.Sprintf(`"msg":"invocation","function":"frenchizer","target":%q,"method":"POST","path":"/numbers","status":400,`, grpcAddress)))
+		})
+
+		It("logs invocations whose function is not resolved as unresolved, whatever their X-Riff header", func() {
+			response, err := http.DefaultClient.Do(post(adapterAddress+"/letters", map[string]string{"X-Riff": "frenchizer"}, "a"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(404))
+			assertClose(response.Body)
+
+			Eventually(logs).Should(gbytes.Say(`"msg":"invocation","function":"unresolved","method":"POST","path":"/letters","status":404,`))
+		})
+	})
+})
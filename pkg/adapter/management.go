@@ -0,0 +1,25 @@
+package adapter
+
+import (
+	"net/http"
+)
+
+// Serves the health endpoints and the metrics under the path prefix, and delegates every other request to next
+type managementHandler struct {
+	prefix  string
+	adapter *StreamingAdapter
+	next    http.Handler
+}
+
+func (handler *managementHandler) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	switch request.URL.Path {
+	case handler.prefix + LivenessPath:
+		serveLiveness(responseWriter)
+	case handler.prefix + ReadinessPath:
+		handler.adapter.serveReadiness(responseWriter, request)
+	case handler.prefix + MetricsPath:
+		handler.adapter.metrics.handler().ServeHTTP(responseWriter, request)
+	default:
+		handler.next.ServeHTTP(responseWriter, request)
+	}
+}
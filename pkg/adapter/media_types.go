@@ -0,0 +1,43 @@
+package adapter
+
+import (
+	"mime"
+	"strings"
+)
+
+// Tells whether the given Accept header value explicitly lists the given media type
+func accepts(accept string, mediaType string) bool {
+	for _, acceptedType := range parseAccept(accept) {
+		if acceptedType == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the given media type from the Accept header value, falling back to fallback if nothing is left
+func withoutMediaType(accept string, mediaType string, fallback string) string {
+	var remaining []string
+	for _, acceptedRange := range strings.Split(accept, ",") {
+		if parsed, _, err := mime.ParseMediaType(acceptedRange); err == nil && parsed == mediaType {
+			continue
+		}
+		if trimmed := strings.TrimSpace(acceptedRange); trimmed != "" {
+			remaining = append(remaining, trimmed)
+		}
+	}
+	if len(remaining) == 0 {
+		return fallback
+	}
+	return strings.Join(remaining, ", ")
+}
+
+func parseAccept(accept string) []string {
+	var result []string
+	for _, acceptedRange := range strings.Split(accept, ",") {
+		if mediaType, _, err := mime.ParseMediaType(acceptedRange); err == nil {
+			result = append(result, mediaType)
+		}
+	}
+	return result
+}
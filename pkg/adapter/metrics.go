@@ -0,0 +1,296 @@
+package adapter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MetricsPath     = "/metrics"
+	metricNamespace = "riff_streaming_adapter"
+	// function label of the requests whose function is not resolved
+	unresolvedFunction = "unresolved"
+	// function label of the requests resolved without matching a function name, e.g. by PassthroughResolver
+	otherFunction = "other"
+)
+
+// Invocation phases, timed from the beginning of the invocation
+const (
+	// until the gRPC server of the function is resolved
+	resolvePhase = "resolve"
+	// until the stream to the gRPC server is open
+	dialPhase = "dial"
+	// only traced, while the request is sent to the function
+	sendPhase = "send"
+	// only traced, while the response is received from the function
+	receivePhase = "receive"
+	// until the function emits its first frame
+	firstFramePhase = "first_frame"
+	// until the HTTP response completes
+	totalPhase = "total"
+)
+
+var (
+	// invocations may last as long as clients want them to
+	durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+	// from 64B to 16MiB
+	sizeBuckets = prometheus.ExponentialBuckets(64, 4, 10)
+)
+
+// Prometheus metrics of the invocations, labelled by function, i.e. by the name the resolver matched the request to.
+// Request headers are never used as labels as is, as clients could then create any number of series.
+// Every adapter has its own registry, which also collects Go runtime and process metrics.
+type adapterMetrics struct {
+	registry      *prometheus.Registry
+	requests      *prometheus.CounterVec
+	durations     *prometheus.HistogramVec
+	inFlight      *prometheus.GaugeVec
+	requestSizes  *prometheus.HistogramVec
+	responseSizes *prometheus.HistogramVec
+	denials       prometheus.Counter
+}
+
+func newAdapterMetrics() *adapterMetrics {
+	metrics := &adapterMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "requests_total",
+			Help:      "Count of the HTTP requests, by function and response status.",
+		}, []string{"function", "code"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Time elapsed from the beginning of the HTTP requests to the end of each phase: resolve, dial, first_frame and total.",
+			Buckets:   durationBuckets,
+		}, []string{"function", "phase"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "requests_in_flight",
+			Help:      "Count of the HTTP requests in progress, by function.",
+		}, []string{"function"}),
+		requestSizes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "request_size_bytes",
+			Help:      "Size of the HTTP request bodies, by function.",
+			Buckets:   sizeBuckets,
+		}, []string{"function"}),
+		responseSizes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "response_size_bytes",
+			Help:      "Size of the HTTP response bodies, by function.",
+			Buckets:   sizeBuckets,
+		}, []string{"function"}),
+		denials: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "denied_resolutions_total",
+			Help:      "Count of the function targets denied by the resolver policy.",
+		}),
+	}
+	metrics.registry.MustRegister(
+		metrics.requests,
+		metrics.durations,
+		metrics.inFlight,
+		metrics.requestSizes,
+		metrics.responseSizes,
+		metrics.denials,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return metrics
+}
+
+// Exposes the metrics in the Prometheus text format
+func (metrics *adapterMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{})
+}
+
+// Collects the statistics of the connection pool, if the resolver is one
+func (metrics *adapterMetrics) registerResolver(resolver ServiceResolver) {
+	if pool, ok := resolver.(poolStatsReporter); ok {
+		metrics.registry.MustRegister(&poolCollector{pool: pool})
+	}
+}
+
+// Counts the requests served by next, along with their duration and body sizes
+func (metrics *adapterMetrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		started := time.Now()
+		label := &functionLabel{value: unresolvedFunction, inFlight: metrics.inFlight.WithLabelValues(unresolvedFunction)}
+		label.inFlight.Inc()
+		body := &countingReader{ReadCloser: request.Body}
+		request.Body = body
+		request = request.WithContext(context.WithValue(request.Context(), functionLabelKey{}, label))
+		recorder := &recordingResponseWriter{ResponseWriter: responseWriter}
+		// handlers abort by panicking, see abortIfCommitted
+		defer func() {
+			label.inFlight.Dec()
+			metrics.requests.WithLabelValues(label.value, strconv.Itoa(recorder.statusCode())).Inc()
+			metrics.observePhase(request, totalPhase, time.Since(started))
+			metrics.requestSizes.WithLabelValues(label.value).Observe(float64(atomic.LoadInt64(&body.size)))
+			metrics.responseSizes.WithLabelValues(label.value).Observe(float64(recorder.size))
+		}()
+		next.ServeHTTP(recorder, request)
+	})
+}
+
+// Records the time elapsed since the beginning of the request, at the end of the given phase.
+// Nothing is recorded when metrics are disabled.
+func (metrics *adapterMetrics) observePhase(request *http.Request, phase string, elapsed time.Duration) {
+	if metrics == nil {
+		return
+	}
+	metrics.durations.WithLabelValues(functionLabelOf(request).value, phase).Observe(elapsed.Seconds())
+}
+
+// Labels the metrics of the request with the function its resolver matched, see matchFunction, from then on.
+// Nothing is labelled when metrics are disabled.
+func (metrics *adapterMetrics) observeResolution(request *http.Request, target string) {
+	label, found := request.Context().Value(functionLabelKey{}).(*functionLabel)
+	if metrics == nil || !found {
+		return
+	}
+	label.inFlight.Dec()
+	label.target = target
+	label.value = label.matched
+	if label.value == "" {
+		label.value = otherFunction
+	}
+	label.inFlight = metrics.inFlight.WithLabelValues(label.value)
+	label.inFlight.Inc()
+}
+
+// Counts the requests whose target the resolver policy denied, see PolicyResolver.
+// Nothing is counted when metrics are disabled.
+func (metrics *adapterMetrics) observeDenial() {
+	if metrics == nil {
+		return
+	}
+	metrics.denials.Inc()
+}
+
+type functionLabelKey struct{}
+
+// Function label of the metrics of a request, also logged, only ever accessed by the goroutine serving it
+type functionLabel struct {
+	value string
+	// address of the gRPC server the request resolved to, only logged
+	target string
+	// name matched by the resolver, only used as label once the resolution succeeds
+	matched  string
+	inFlight prometheus.Gauge
+}
+
+func functionLabelOf(request *http.Request) *functionLabel {
+	if label, found := request.Context().Value(functionLabelKey{}).(*functionLabel); found {
+		return label
+	}
+	return &functionLabel{value: unresolvedFunction}
+}
+
+// Records the name of the function the resolver matched the request to.
+// Resolvers must not match arbitrary addresses, as clients could then create any number of series.
+func matchFunction(request *http.Request, name string) {
+	if label, found := request.Context().Value(functionLabelKey{}).(*functionLabel); found {
+		label.matched = name
+	}
+}
+
+type poolStatsReporter interface {
+	Stats() PoolStats
+}
+
+// Reports the statistics of a connection pool at scrape time
+type poolCollector struct {
+	pool poolStatsReporter
+}
+
+var (
+	poolConnectionsDesc = prometheus.NewDesc(metricNamespace+"_pool_connections", "Count of the open connections to gRPC servers.", nil, nil)
+	poolInUseDesc       = prometheus.NewDesc(metricNamespace+"_pool_connections_in_use", "Count of the invocations using a pooled connection.", nil, nil)
+	poolDialsDesc       = prometheus.NewDesc(metricNamespace+"_pool_dials_total", "Count of the connections dialed.", nil, nil)
+	poolHitsDesc        = prometheus.NewDesc(metricNamespace+"_pool_hits_total", "Count of the connections reused.", nil, nil)
+	poolEvictionsDesc   = prometheus.NewDesc(metricNamespace+"_pool_evictions_total", "Count of the connections evicted, either idle, failing or shut down.", nil, nil)
+)
+
+func (collector *poolCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- poolConnectionsDesc
+	descs <- poolInUseDesc
+	descs <- poolDialsDesc
+	descs <- poolHitsDesc
+	descs <- poolEvictionsDesc
+}
+
+func (collector *poolCollector) Collect(metrics chan<- prometheus.Metric) {
+	stats := collector.pool.Stats()
+	metrics <- prometheus.MustNewConstMetric(poolConnectionsDesc, prometheus.GaugeValue, float64(stats.Connections))
+	metrics <- prometheus.MustNewConstMetric(poolInUseDesc, prometheus.GaugeValue, float64(stats.InUse))
+	metrics <- prometheus.MustNewConstMetric(poolDialsDesc, prometheus.CounterValue, float64(stats.Dials))
+	metrics <- prometheus.MustNewConstMetric(poolHitsDesc, prometheus.CounterValue, float64(stats.Hits))
+	metrics <- prometheus.MustNewConstMetric(poolEvictionsDesc, prometheus.CounterValue, float64(stats.Evictions))
+}
+
+// Request bodies are read by a separate goroutine
+type countingReader struct {
+	io.ReadCloser
+	size int64
+}
+
+func (reader *countingReader) Read(buffer []byte) (int, error) {
+	count, err := reader.ReadCloser.Read(buffer)
+	atomic.AddInt64(&reader.size, int64(count))
+	return count, err
+}
+
+// Records the response status and body size, while still letting handlers flush responses and hijack connections
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (writer *recordingResponseWriter) WriteHeader(status int) {
+	if writer.status == 0 {
+		writer.status = status
+	}
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *recordingResponseWriter) Write(body []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = 200
+	}
+	count, err := writer.ResponseWriter.Write(body)
+	writer.size += int64(count)
+	return count, err
+}
+
+func (writer *recordingResponseWriter) Flush() {
+	flush(writer.ResponseWriter)
+}
+
+func (writer *recordingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := writer.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T cannot be hijacked", writer.ResponseWriter)
+	}
+	writer.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// requests for which nothing is written are answered with 200
+func (writer *recordingResponseWriter) statusCode() int {
+	if writer.status == 0 {
+		return 200
+	}
+	return writer.status
+}
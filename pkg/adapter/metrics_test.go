@@ -0,0 +1,151 @@
+package adapter_test
+
+import (
+	"bufio"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"net/http"
+	"riff-streaming-adapter/pkg/adapter"
+	"riff-streaming-adapter/streaming"
+	"time"
+)
+
+var _ = Describe("Metrics", func() {
+
+	var (
+		grpcConnection   *grpc.ClientConn
+		grpcAddress      string
+		streamingAdapter *adapter.StreamingAdapter
+		adapterAddress   string
+		httpClient       *http.Client
+	)
+
+	start := func(server streaming.RiffServer, resolver func() adapter.ServiceResolver) {
+		grpcConnection, grpcAddress = openGrpcConnection(server)
+		httpPort := findFreePort()
+		streamingAdapter = &adapter.StreamingAdapter{
+			ServiceResolver: resolver(),
+			Timeout:         time.Minute,
+		}
+		Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+		adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+		httpClient = &http.Client{}
+	}
+
+	hardcodedResolver := func() adapter.ServiceResolver {
+		return &HardcodedResolver{Url: grpcAddress}
+	}
+
+	routingResolver := func() adapter.ServiceResolver {
+		resolver, err := adapter.NewRoutingResolver([]adapter.Route{{Target: adapter.Target{Address: grpcAddress}, Function: "frenchizer"}})
+		Expect(err).NotTo(HaveOccurred())
+		return resolver
+	}
+
+	scrape := func() string {
+		response, err := httpClient.Get(adapterAddress + "/metrics")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(200))
+		return asString(response.Body)
+	}
+
+	AfterEach(func() {
+		assertClose(streamingAdapter)
+		assertClose(grpcConnection)
+	})
+
+	It("counts requests by function and status, along with their phases and sizes", func() {
+		start(NewFrenchizerServer(), routingResolver)
+
+		response, err := httpClient.Do(post(adapterAddress, map[string]string{"X-Riff": "frenchizer", "Accept": "text/plain"}, "1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(asString(response.Body)).To(Equal("un"))
+		response, err = httpClient.Do(post(adapterAddress, map[string]string{"X-Riff": "frenchizer", "X-Riff-Timeout": "oops"}, "1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(400))
+		assertClose(response.Body)
+
+		metrics := scrape()
+
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_requests_total{code="200",function="frenchizer"} 1`))
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_requests_total{code="400",function="frenchizer"} 1`))
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_request_duration_seconds_count{function="frenchizer",phase="resolve"} 2`))
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_request_duration_seconds_count{function="frenchizer",phase="dial"} 1`))
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_request_duration_seconds_count{function="frenchizer",phase="first_frame"} 1`))
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_request_duration_seconds_count{function="frenchizer",phase="total"} 2`))
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_request_size_bytes_sum{function="frenchizer"} 1`))
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_requests_in_flight{function="frenchizer"} 0`))
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_requests_in_flight{function="unresolved"} 0`))
+		Expect(metrics).NotTo(ContainSubstring(`riff_streaming_adapter_pool_`))
+	})
+
+	It("counts requests in flight", func() {
+		start(NewTickerServer(10*time.Millisecond), hardcodedResolver)
+		response, err := httpClient.Do(post(adapterAddress, map[string]string{"X-Riff": "ticker"}, ""))
+		Expect(err).NotTo(HaveOccurred())
+		defer assertClose(response.Body)
+		Expect(bufio.NewReader(response.Body).ReadString('\n')).To(Equal("tick\n"))
+
+		Expect(scrape()).To(ContainSubstring(`riff_streaming_adapter_requests_in_flight{function="other"} 1`))
+	})
+
+	It("counts requests resolved to the addresses clients send as other", func() {
+		start(NewFrenchizerServer(), func() adapter.ServiceResolver {
+			return &adapter.PassthroughResolver{}
+		})
+
+		response, err := httpClient.Do(post(adapterAddress, map[string]string{"X-Riff": grpcAddress, "Accept": "text/plain"}, "1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(asString(response.Body)).To(Equal("un"))
+
+		metrics := scrape()
+
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_requests_total{code="200",function="other"} 1`))
+		Expect(metrics).NotTo(ContainSubstring(grpcAddress))
+	})
+
+	It("counts requests whose function is not resolved as unresolved, whatever their X-Riff header", func() {
+		start(NewFrenchizerServer(), func() adapter.ServiceResolver {
+			return &adapter.KnativeServiceResolver{}
+		})
+
+		for _, function := range []string{"one", "two"} {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{"X-Riff": function}, "1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(400))
+			assertClose(response.Body)
+		}
+
+		metrics := scrape()
+
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_requests_total{code="400",function="unresolved"} 2`))
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_request_duration_seconds_count{function="unresolved",phase="resolve"} 2`))
+		Expect(metrics).NotTo(ContainSubstring(`function="one"`))
+	})
+
+	It("reports the statistics of the connection pool", func() {
+		var pool *adapter.ConnectionPool
+		start(NewFrenchizerServer(), func() adapter.ServiceResolver {
+			pool = adapter.NewConnectionPool(&adapter.PassthroughResolver{}, time.Minute)
+			return pool
+		})
+		defer assertClose(pool)
+
+		for i := 0; i < 2; i++ {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{"X-Riff": grpcAddress, "Accept": "text/plain"}, "1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			assertClose(response.Body)
+		}
+
+		metrics := scrape()
+
+		Expect(metrics).To(ContainSubstring("riff_streaming_adapter_pool_connections 1"))
+		Expect(metrics).To(ContainSubstring("riff_streaming_adapter_pool_connections_in_use 0"))
+		Expect(metrics).To(ContainSubstring("riff_streaming_adapter_pool_dials_total 1"))
+		Expect(metrics).To(ContainSubstring("riff_streaming_adapter_pool_hits_total 1"))
+		Expect(metrics).To(ContainSubstring("riff_streaming_adapter_pool_evictions_total 0"))
+	})
+})
@@ -0,0 +1,231 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServiceName = "riff-streaming-adapter"
+	otlpTracesPath     = "/v1/traces"
+	otlpBatchSize      = 512
+	otlpQueueSize      = 4096
+	otlpExportInterval = 5 * time.Second
+	otlpExportTimeout  = 10 * time.Second
+	otlpStatusError    = 2
+)
+
+// SpanExporter sending spans in batches to an OpenTelemetry collector, with the OTLP/HTTP JSON protocol.
+// Spans are dropped when the queue is full, i.e. when the collector cannot keep up.
+type OtlpExporter struct {
+	// logs export failures, if set
+	Logger      *Logger
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	mutex       sync.Mutex
+	spans       chan *Span
+	closed      bool
+	done        chan struct{}
+}
+
+// Creates an exporter to the given collector URL, e.g. "http://localhost:4318". The "/v1/traces" path is appended
+// when the URL has no path. The service name defaults to "riff-streaming-adapter".
+func NewOtlpExporter(endpoint string, serviceName string) (*OtlpExporter, error) {
+	parsedEndpoint, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if parsedEndpoint.Scheme != "http" && parsedEndpoint.Scheme != "https" {
+		return nil, fmt.Errorf("%q is invalid: expected an http or https URL", endpoint)
+	}
+	if parsedEndpoint.Path == "" || parsedEndpoint.Path == "/" {
+		parsedEndpoint.Path = otlpTracesPath
+	}
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	exporter := &OtlpExporter{
+		endpoint:    parsedEndpoint.String(),
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: otlpExportTimeout},
+		spans:       make(chan *Span, otlpQueueSize),
+		done:        make(chan struct{}),
+	}
+	go exporter.exportBatches()
+	return exporter, nil
+}
+
+func (exporter *OtlpExporter) Export(span *Span) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	if exporter.closed {
+		return
+	}
+	select {
+	case exporter.spans <- span:
+	default:
+	}
+}
+
+// Exports the queued spans, spans exported afterwards are dropped
+func (exporter *OtlpExporter) Close() error {
+	exporter.mutex.Lock()
+	if !exporter.closed {
+		exporter.closed = true
+		close(exporter.spans)
+	}
+	exporter.mutex.Unlock()
+	<-exporter.done
+	return nil
+}
+
+func (exporter *OtlpExporter) exportBatches() {
+	defer close(exporter.done)
+	ticker := time.NewTicker(otlpExportInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case span, open := <-exporter.spans:
+			if !open {
+				exporter.send(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= otlpBatchSize {
+				exporter.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			exporter.send(batch)
+			batch = nil
+		}
+	}
+}
+
+func (exporter *OtlpExporter) send(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(exporter.request(batch))
+	if err != nil {
+		exporter.Logger.Error("error when encoding spans", "error", err)
+		return
+	}
+	response, err := exporter.client.Post(exporter.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		exporter.Logger.Warn("error when exporting spans", "endpoint", exporter.endpoint, "spans", len(batch), "error", err)
+		return
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode/100 != 2 {
+		exporter.Logger.Warn("error when exporting spans", "endpoint", exporter.endpoint, "spans", len(batch), "status", response.StatusCode)
+	}
+}
+
+// JSON mapping of the OTLP ExportTraceServiceRequest message, IDs are hex-encoded
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// 64-bit integers are encoded as strings
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (exporter *OtlpExporter) request(batch []*Span) *otlpRequest {
+	spans := make([]otlpSpan, len(batch))
+	for i, span := range batch {
+		spans[i] = otlpSpan{
+			TraceId:           span.TraceId.String(),
+			SpanId:            span.SpanId.String(),
+			TraceState:        span.traceState,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+		}
+		if span.ParentSpanId != (SpanId{}) {
+			spans[i].ParentSpanId = span.ParentSpanId.String()
+		}
+		if span.Error != nil {
+			spans[i].Status = &otlpStatus{Code: otlpStatusError, Message: span.Error.Error()}
+		}
+	}
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(map[string]interface{}{"service.name": exporter.serviceName})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: defaultServiceName}, Spans: spans}},
+	}}}
+}
+
+// Integers are encoded as such, any other value as a string
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	var result []otlpAttribute
+	for key, value := range attributes {
+		var encodedValue otlpValue
+		if intValue, ok := value.(int); ok {
+			formatted := strconv.Itoa(intValue)
+			encodedValue.IntValue = &formatted
+		} else {
+			formatted := fmt.Sprint(value)
+			encodedValue.StringValue = &formatted
+		}
+		result = append(result, otlpAttribute{Key: key, Value: encodedValue})
+	}
+	return result
+}
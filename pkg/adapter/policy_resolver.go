@@ -0,0 +1,252 @@
+package adapter
+
+import (
+	"fmt"
+	"google.golang.org/grpc"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Targets a PolicyResolver rule applies to. A target matches a rule when it matches all of its non-empty criteria.
+type PolicyRule struct {
+	// glob patterns, e.g. "team-*", matched against the namespace of Kubernetes service targets,
+	// i.e. targets named SERVICE.NAMESPACE.svc or SERVICE.NAMESPACE.svc.CLUSTER_DOMAIN
+	Namespaces []string
+	// glob patterns matched against the service name of Kubernetes service targets, and against the host of the others
+	Services []string
+	// CIDR ranges, e.g. "10.0.0.0/8", matched against the IP address of the targets.
+	// Targets named by host name are matched against the addresses the host is looked up to, see PolicyResolver.
+	Networks []string
+	Ports    []int
+}
+
+// TargetResolver, and ServiceResolver, only letting invocations reach the targets the policy allows: targets matching
+// any deny rule are rejected, as are, when there are allow rules, the targets matching none of them.
+// Rejected invocations fail with a 403 problem, before any connection is dialed.
+// When rules restrict networks, host names are looked up once and the first of their addresses the policy allows is
+// dialed in their stead, with the host as authority, so that hosts cannot resolve to other addresses once dialed.
+type PolicyResolver struct {
+	// domain of the Kubernetes cluster, defaults to "cluster.local"
+	ClusterDomain string
+	// looks host names up, defaults to net.DefaultResolver
+	Lookup   *net.Resolver
+	resolver TargetResolver
+	allow    []policyRule
+	deny     []policyRule
+	// whether any rule restricts networks
+	networks bool
+}
+
+type policyRule struct {
+	PolicyRule
+	networks []*net.IPNet
+}
+
+// Coordinates of a target, as matched by policy rules
+type policyTarget struct {
+	// IP address or host name, empty if unknown
+	host      string
+	service   string
+	namespace string
+	// 0 if unknown
+	port int
+	// nil unless the host is an IP address, or has been looked up
+	ip net.IP
+}
+
+func NewPolicyResolver(resolver TargetResolver, allow []PolicyRule, deny []PolicyRule) (*PolicyResolver, error) {
+	policy := &PolicyResolver{resolver: resolver}
+	var err error
+	if policy.allow, err = compileRules("allow", allow); err != nil {
+		return nil, err
+	}
+	if policy.deny, err = compileRules("deny", deny); err != nil {
+		return nil, err
+	}
+	for _, rule := range append(append([]policyRule{}, policy.allow...), policy.deny...) {
+		policy.networks = policy.networks || len(rule.networks) > 0
+	}
+	return policy, nil
+}
+
+func compileRules(kind string, rules []PolicyRule) ([]policyRule, error) {
+	result := make([]policyRule, len(rules))
+	for i, rule := range rules {
+		result[i].PolicyRule = rule
+		for _, pattern := range append(append([]string{}, rule.Namespaces...), rule.Services...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%s rule %d: %q is invalid: expected a glob pattern", kind, i, pattern)
+			}
+		}
+		for _, network := range rule.Networks {
+			_, parsedNetwork, err := net.ParseCIDR(network)
+			if err != nil {
+				return nil, fmt.Errorf("%s rule %d: %q is invalid: expected a CIDR range such as 10.0.0.0/8", kind, i, network)
+			}
+			result[i].networks = append(result[i].networks, parsedNetwork)
+		}
+		for _, port := range rule.Ports {
+			if port <= 0 || port > 65535 {
+				return nil, fmt.Errorf("%s rule %d: %d is invalid: expected a port between 1 and 65535", kind, i, port)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (policy *PolicyResolver) Resolve(request *http.Request) (*grpc.ClientConn, error) {
+	return dialTarget(policy, request)
+}
+
+func (policy *PolicyResolver) ResolveTarget(request *http.Request) (Target, error) {
+	target, err := policy.resolver.ResolveTarget(request)
+	if err != nil {
+		return Target{}, err
+	}
+	parsedTarget := parsePolicyTarget(target.Address, policy.clusterDomain())
+	if policy.networks && parsedTarget.host != "" && parsedTarget.ip == nil {
+		return policy.resolveHost(request, target, parsedTarget)
+	}
+	if !policy.allows(parsedTarget) {
+		return Target{}, forbiddenTarget(fmt.Sprintf("%q is not allowed", target.Address))
+	}
+	return target, nil
+}
+
+// Looks the host of the target up, and returns the first of its addresses the policy allows
+func (policy *PolicyResolver) resolveHost(request *http.Request, target Target, parsedTarget *policyTarget) (Target, error) {
+	lookup := policy.Lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver
+	}
+	addresses, err := lookup.LookupIPAddr(request.Context(), parsedTarget.host)
+	if err != nil {
+		return Target{}, err
+	}
+	port := defaultGrpcPort
+	if parsedTarget.port > 0 {
+		port = strconv.Itoa(parsedTarget.port)
+	}
+	authority := target.Authority
+	if authority == "" {
+		authority = net.JoinHostPort(parsedTarget.host, port)
+	}
+	for _, address := range addresses {
+		parsedTarget.ip = address.IP
+		if policy.allows(parsedTarget) {
+			return Target{Address: net.JoinHostPort(address.IP.String(), port), Authority: authority}, nil
+		}
+	}
+	return Target{}, forbiddenTarget(fmt.Sprintf("%q is not allowed", target.Address))
+}
+
+func (policy *PolicyResolver) clusterDomain() string {
+	if policy.ClusterDomain == "" {
+		return defaultClusterDomain
+	}
+	return strings.ToLower(strings.Trim(policy.ClusterDomain, "."))
+}
+
+func (policy *PolicyResolver) allows(target *policyTarget) bool {
+	for _, rule := range policy.deny {
+		if matches(rule, target, false) {
+			return false
+		}
+	}
+	if len(policy.allow) == 0 {
+		return true
+	}
+	for _, rule := range policy.allow {
+		if matches(rule, target, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// Targets of unknown host match deny rules, but no allow rule
+func matches(rule policyRule, target *policyTarget, allow bool) bool {
+	if target.host == "" {
+		return !allow
+	}
+	if len(rule.Namespaces) > 0 && !matchesAny(rule.Namespaces, target.namespace) {
+		return false
+	}
+	if len(rule.Services) > 0 && !matchesAny(rule.Services, target.service) {
+		return false
+	}
+	if len(rule.Ports) > 0 && !containsPort(rule.Ports, target.port) {
+		return false
+	}
+	if len(rule.networks) == 0 {
+		return true
+	}
+	return target.ip != nil && inNetworks(rule.networks, target.ip)
+}
+
+// port gRPC dials when targets have none
+const defaultGrpcPort = "443"
+
+// Addresses are either host:port, host, or gRPC targets such as dns:///host:port.
+// Hosts of the targets of other schemes are unknown.
+// Only hosts named SERVICE.NAMESPACE.svc or SERVICE.NAMESPACE.svc.CLUSTER_DOMAIN are Kubernetes services, so that
+// hosts such as square.default.svc.example.com cannot pass for one.
+func parsePolicyTarget(address string, clusterDomain string) *policyTarget {
+	if separator := strings.Index(address, "://"); separator >= 0 {
+		scheme := address[:separator]
+		if scheme != "dns" && scheme != "passthrough" {
+			return &policyTarget{}
+		}
+		endpoint := address[separator+3:]
+		slash := strings.Index(endpoint, "/")
+		if slash < 0 {
+			return &policyTarget{}
+		}
+		address = endpoint[slash+1:]
+	}
+	target := &policyTarget{host: address}
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		target.host = host
+		target.port, _ = strconv.Atoi(port)
+	}
+	target.host = strings.ToLower(strings.TrimSuffix(target.host, "."))
+	target.service = target.host
+	if target.ip = net.ParseIP(target.host); target.ip != nil {
+		return target
+	}
+	labels := strings.Split(target.host, ".")
+	if len(labels) >= 3 && labels[2] == "svc" && (len(labels) == 3 || strings.Join(labels[3:], ".") == clusterDomain) {
+		target.service, target.namespace = labels[0], labels[1]
+	}
+	return target
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched && value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, candidate := range ports {
+		if candidate == port {
+			return true
+		}
+	}
+	return false
+}
+
+func inNetworks(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package adapter_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"net"
+	"net/http"
+	"riff-streaming-adapter/pkg/adapter"
+)
+
+var _ = Describe("Policy resolver", func() {
+
+	request := func(address string) *http.Request {
+		return &http.Request{Header: http.Header{"X-Riff": {address}}}
+	}
+
+	DescribeTable("allows or denies targets",
+		func(allow []adapter.PolicyRule, deny []adapter.PolicyRule, address string, allowed bool) {
+			resolver, err := adapter.NewPolicyResolver(&adapter.PassthroughResolver{}, allow, deny)
+			Expect(err).NotTo(HaveOccurred())
+
+			target, err := resolver.ResolveTarget(request(address))
+
+			if allowed {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(target.Address).To(Equal(address))
+			} else {
+				Expect(err).To(MatchError(fmt.Sprintf("%q is not allowed", address)))
+				Expect(err.(*adapter.Problem).Status).To(Equal(403))
+			}
+		},
+		Entry("without rules", nil, nil, "10.0.0.1:8080", true),
+		Entry("allowed namespace", []adapter.PolicyRule{{Namespaces: []string{"team-*"}}}, nil, "square.team-a.svc.cluster.local:8080", true),
+		Entry("other namespace", []adapter.PolicyRule{{Namespaces: []string{"team-*"}}}, nil, "square.kube-system.svc.cluster.local:8080", false),
+		Entry("outside of any namespace", []adapter.PolicyRule{{Namespaces: []string{"*"}}}, nil, "example.com:8080", false),
+		Entry("outside of the cluster domain", []adapter.PolicyRule{{Namespaces: []string{"default"}}}, nil, "square.default.svc.attacker.example:443", false),
+		Entry("outside of the cluster domain, denied host", nil, []adapter.PolicyRule{{Services: []string{"square.default.svc.attacker.example"}}}, "square.default.svc.attacker.example:443", false),
+		Entry("allowed service", []adapter.PolicyRule{{Services: []string{"square", "cube"}}}, nil, "cube.default.svc:8080", true),
+		Entry("allowed host", []adapter.PolicyRule{{Services: []string{"*.example.com"}}}, nil, "functions.example.com:8080", true),
+		Entry("all criteria of a rule", []adapter.PolicyRule{{Services: []string{"square"}, Ports: []int{8080}}}, nil, "square.default.svc:9090", false),
+		Entry("any allow rule", []adapter.PolicyRule{{Ports: []int{8080}}, {Ports: []int{9090}}}, nil, "square.default.svc:9090", true),
+		Entry("allowed port, without port", []adapter.PolicyRule{{Ports: []int{8080}}}, nil, "square.default.svc", false),
+		Entry("allowed network", []adapter.PolicyRule{{Networks: []string{"10.0.0.0/8"}}}, nil, "10.1.2.3:8080", true),
+		Entry("other network", []adapter.PolicyRule{{Networks: []string{"10.0.0.0/8"}}}, nil, "192.168.0.1:8080", false),
+		Entry("denied network", nil, []adapter.PolicyRule{{Networks: []string{"169.254.0.0/16"}}}, "169.254.169.254:80", false),
+		Entry("denied network, of dns target", nil, []adapter.PolicyRule{{Networks: []string{"127.0.0.0/8"}}}, "dns:///127.0.0.1:8080", false),
+		Entry("deny rules first", []adapter.PolicyRule{{Namespaces: []string{"*"}}}, []adapter.PolicyRule{{Namespaces: []string{"kube-system"}}}, "dns.kube-system.svc:53", false),
+		Entry("target of unknown host", nil, []adapter.PolicyRule{{Ports: []int{22}}}, "unix:///var/run/function.sock", false),
+	)
+
+	Describe("with host names", func() {
+
+		var dns *dnsServer
+
+		BeforeEach(func() {
+			dns = NewDnsServer()
+			dns.SetAddresses("square.team-a.svc.cluster.local", net.ParseIP("10.96.0.10"))
+			dns.SetAddresses("metadata.example.com", net.ParseIP("169.254.169.254"))
+			dns.SetAddresses("functions.example.com", net.ParseIP("169.254.169.254"), net.ParseIP("10.20.0.2"))
+		})
+
+		AfterEach(func() {
+			assertClose(dns)
+		})
+
+		// the example policy of the README
+		allow := []adapter.PolicyRule{
+			{Namespaces: []string{"team-*"}, Services: []string{"square", "cube"}, Ports: []int{8080}},
+			{Networks: []string{"10.20.0.0/16"}},
+		}
+		deny := []adapter.PolicyRule{{Networks: []string{"169.254.0.0/16"}}}
+
+		DescribeTable("dials the first address of the host the policy allows, with the host as authority",
+			func(allow []adapter.PolicyRule, deny []adapter.PolicyRule, address string, expectedTarget adapter.Target) {
+				resolver, err := adapter.NewPolicyResolver(&adapter.PassthroughResolver{}, allow, deny)
+				Expect(err).NotTo(HaveOccurred())
+				resolver.Lookup = dns.Resolver()
+
+				target, err := resolver.ResolveTarget(request(address))
+
+				if expectedTarget == (adapter.Target{}) {
+					Expect(err).To(MatchError(fmt.Sprintf("%q is not allowed", address)))
+					Expect(err.(*adapter.Problem).Status).To(Equal(403))
+				} else {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(target).To(Equal(expectedTarget))
+				}
+			},
+			Entry("Kubernetes service, under a network deny rule", allow, deny, "square.team-a.svc.cluster.local:8080",
+				adapter.Target{Address: "10.96.0.10:8080", Authority: "square.team-a.svc.cluster.local:8080"}),
+			Entry("denied network", allow, deny, "metadata.example.com:8080", adapter.Target{}),
+			Entry("denied and allowed networks", allow, deny, "functions.example.com:8080",
+				adapter.Target{Address: "10.20.0.2:8080", Authority: "functions.example.com:8080"}),
+			Entry("allowed network", []adapter.PolicyRule{{Networks: []string{"10.0.0.0/8"}}}, nil, "square.team-a.svc.cluster.local",
+				adapter.Target{Address: "10.96.0.10:443", Authority: "square.team-a.svc.cluster.local:443"}),
+			Entry("other network", []adapter.PolicyRule{{Networks: []string{"192.168.0.0/16"}}}, nil, "square.team-a.svc.cluster.local:8080", adapter.Target{}),
+			Entry("dns target", nil, deny, "dns:///functions.example.com:8080",
+				adapter.Target{Address: "10.20.0.2:8080", Authority: "functions.example.com:8080"}),
+		)
+
+		It("keeps the authority of the target", func() {
+			resolver, err := adapter.NewPolicyResolver(&adapter.PassthroughResolver{}, nil, deny)
+			Expect(err).NotTo(HaveOccurred())
+			resolver.Lookup = dns.Resolver()
+			request := request("square.team-a.svc.cluster.local:8080")
+			request.Header.Set("X-Riff-Authority", "square.example.com")
+
+			Expect(resolver.ResolveTarget(request)).To(Equal(adapter.Target{Address: "10.96.0.10:8080", Authority: "square.example.com"}))
+		})
+
+		It("fails when hosts cannot be looked up", func() {
+			resolver, err := adapter.NewPolicyResolver(&adapter.PassthroughResolver{}, nil, deny)
+			Expect(err).NotTo(HaveOccurred())
+			resolver.Lookup = dns.Resolver()
+
+			_, err = resolver.ResolveTarget(request("unknown.example.com:8080"))
+
+			Expect(err).To(HaveOccurred())
+			Expect(err).NotTo(BeAssignableToTypeOf(&adapter.Problem{}))
+		})
+
+		It("does not look hosts up when no rule restricts networks", func() {
+			resolver, err := adapter.NewPolicyResolver(&adapter.PassthroughResolver{}, allow[:1], nil)
+			Expect(err).NotTo(HaveOccurred())
+			resolver.Lookup = dns.Resolver()
+
+			Expect(resolver.ResolveTarget(request("square.team-a.svc.cluster.local:8080"))).To(Equal(adapter.Target{Address: "square.team-a.svc.cluster.local:8080"}))
+			Expect(dns.Queries("square.team-a.svc.cluster.local")).To(Equal(0))
+		})
+	})
+
+	It("names Kubernetes service targets after the cluster domain", func() {
+		resolver, err := adapter.NewPolicyResolver(&adapter.PassthroughResolver{}, []adapter.PolicyRule{{Namespaces: []string{"default"}}}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		resolver.ClusterDomain = "example.org"
+
+		Expect(resolver.ResolveTarget(request("square.default.svc.example.org:8080"))).To(Equal(adapter.Target{Address: "square.default.svc.example.org:8080"}))
+		_, err = resolver.ResolveTarget(request("square.default.svc.cluster.local:8080"))
+		Expect(err).To(MatchError(`"square.default.svc.cluster.local:8080" is not allowed`))
+	})
+
+	DescribeTable("rejects invalid rules",
+		func(rule adapter.PolicyRule, expectedError string) {
+			_, err := adapter.NewPolicyResolver(&adapter.PassthroughResolver{}, nil, []adapter.PolicyRule{{Ports: []int{22}}, rule})
+
+			Expect(err).To(MatchError(expectedError))
+		},
+		Entry("invalid glob", adapter.PolicyRule{Services: []string{"[square"}}, `deny rule 1: "[square" is invalid: expected a glob pattern`),
+		Entry("invalid network", adapter.PolicyRule{Networks: []string{"10.0.0.0"}}, `deny rule 1: "10.0.0.0" is invalid: expected a CIDR range such as 10.0.0.0/8`),
+		Entry("invalid port", adapter.PolicyRule{Ports: []int{0}}, "deny rule 1: 0 is invalid: expected a port between 1 and 65535"),
+	)
+
+	It("answers denied invocations with 403, and counts them", func() {
+		grpcConnection, grpcAddress := openGrpcConnection(NewFrenchizerServer())
+		defer assertClose(grpcConnection)
+		resolver, err := adapter.NewPolicyResolver(&adapter.PassthroughResolver{}, nil, []adapter.PolicyRule{{Networks: []string{"10.0.0.0/8"}}})
+		Expect(err).NotTo(HaveOccurred())
+		_, adapterAddress, stop := startPooledAdapter(resolver)
+		defer stop()
+
+		response, err := http.DefaultClient.Do(post(adapterAddress, map[string]string{"X-Riff": "10.0.0.1:8080", "Accept": "application/json"}, "1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(403))
+		Expect(asProblem(response.Body).Type).To(Equal("urn:riff:streaming-adapter:problem:forbidden-target"))
+		// host names are looked up, and the address the policy allows dialed
+		response, err = http.DefaultClient.Do(post(adapterAddress, map[string]string{"X-Riff": grpcAddress, "Accept": "text/plain"}, "1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(asString(response.Body)).To(Equal("un"))
+
+		response, err = http.DefaultClient.Get(adapterAddress + "/metrics")
+		Expect(err).NotTo(HaveOccurred())
+		metrics := asString(response.Body)
+		Expect(metrics).To(ContainSubstring("riff_streaming_adapter_denied_resolutions_total 1"))
+		Expect(metrics).To(ContainSubstring(`riff_streaming_adapter_requests_total{code="403",function="unresolved"} 1`))
+	})
+})
@@ -0,0 +1,134 @@
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	problemMediaType  = "application/problem+json"
+	problemTypePrefix = "urn:riff:streaming-adapter:problem:"
+)
+
+// RFC 7807 description of a failed invocation.
+// It is rendered as application/problem+json to the clients accepting either that or application/json,
+// and as text/plain otherwise.
+type Problem struct {
+	// URI reference identifying the problem type
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// name of the invoked function, as set in the X-Riff header
+	Function string `json:"function,omitempty"`
+	// ID of the failed request, as set in the X-Request-Id header, see identifyRequests
+	RequestId string `json:"requestId,omitempty"`
+}
+
+func (problem *Problem) Error() string {
+	if problem.Detail == "" {
+		return problem.Title
+	}
+	return problem.Detail
+}
+
+func missingFunctionName(detail string) *Problem {
+	return newProblem("missing-function-name", 400, "missing function name", detail)
+}
+
+func invalidFunctionName(detail string) *Problem {
+	return newProblem("invalid-function-name", 400, "invalid function name", detail)
+}
+
+func unknownFunction(detail string) *Problem {
+	return newProblem("unknown-function", 404, "unknown function", detail)
+}
+
+func noMatchingRoute(detail string) *Problem {
+	return newProblem("no-matching-route", 404, "no matching route", detail)
+}
+
+func unknownHost(detail string) *Problem {
+	return newProblem("unknown-host", 404, "no function matches the host", detail)
+}
+
+func forbiddenOrigin(detail string) *Problem {
+	return newProblem("forbidden-origin", 403, "WebSocket origin not allowed", detail)
+}
+
+func forbiddenTarget(detail string) *Problem {
+	return newProblem("forbidden-target", 403, "function target denied by policy", detail)
+}
+
+const forbiddenTargetType = problemTypePrefix + "forbidden-target"
+
+func invalidTimeout(detail string) *Problem {
+	return newProblem("invalid-timeout", 400, "invalid timeout", detail)
+}
+
+func unreachableFunction(err error) *Problem {
+	return newProblem("unreachable-function", 502, "unreachable gRPC server", err.Error())
+}
+
+func functionTimeout() *Problem {
+	return newProblem("function-timeout", 504, "upstream gRPC server did not respond in time", "")
+}
+
+// nginx's non-standard status, the client will not see it anyway
+func clientClosedRequest() *Problem {
+	return newProblem("client-closed-request", 499, "client closed request", "")
+}
+
+func shuttingDown() *Problem {
+	return newProblem("shutting-down", 503, "streaming adapter is shutting down", "")
+}
+
+func unreadableRequestBody(err error) *Problem {
+	return newProblem("unreadable-request-body", 400, "unreadable request body", err.Error())
+}
+
+func requestBodyTooLarge(limit int64) *Problem {
+	return newProblem("request-body-too-large", 413, "request body too large", fmt.Sprintf("request bodies are limited to %d bytes", limit))
+}
+
+func invalidFunctionResponse(detail string) *Problem {
+	return newProblem("invalid-function-response", 502, "misbehaving gRPC server", detail)
+}
+
+func newProblem(problemType string, status int, title string, detail string) *Problem {
+	return &Problem{
+		Type:   problemTypePrefix + problemType,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Renders the problem according to the request Accept header. Text renditions only mention the title,
+// followed by the detail if any.
+func writeProblem(responseWriter http.ResponseWriter, request *http.Request, problem *Problem) error {
+	rendered := *problem
+	rendered.Function = request.Header.Get("X-Riff")
+	rendered.RequestId = request.Header.Get(RequestIdHeader)
+	accept := request.Header.Get("Accept")
+	if accepts(accept, problemMediaType) || accepts(accept, "application/json") {
+		body, err := json.Marshal(&rendered)
+		if err != nil {
+			return err
+		}
+		return writeBody(responseWriter, rendered.Status, problemMediaType, body)
+	}
+	body := rendered.Title
+	if rendered.Detail != "" {
+		body = fmt.Sprintf("%s: %s", rendered.Title, rendered.Detail)
+	}
+	return writeBody(responseWriter, rendered.Status, "text/plain; charset=utf-8", []byte(body))
+}
+
+func writeBody(responseWriter http.ResponseWriter, status int, contentType string, body []byte) error {
+	responseWriter.Header().Set("Content-Type", contentType)
+	responseWriter.WriteHeader(status)
+	_, err := responseWriter.Write(body)
+	return err
+}
@@ -0,0 +1,171 @@
+package adapter
+
+import (
+	"bytes"
+	"fmt"
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultReloadInterval = time.Second
+
+// Content of a registry file, in YAML or JSON, e.g.
+//
+//	functions:
+//	  square:
+//	    addresses: [10.0.0.1:8081, 10.0.0.2:8081]
+//	    authority: square.example.com
+type registryFile struct {
+	Functions map[string]registryFunction `yaml:"functions"`
+}
+
+type registryFunction struct {
+	Addresses []string `yaml:"addresses"`
+	// defaults to the X-Riff-Authority header
+	Authority string `yaml:"authority"`
+}
+
+// Registered function, whose addresses are picked in turn
+type registryEntry struct {
+	addresses []string
+	authority string
+	next      uint32
+}
+
+// Resolves the function named in the X-Riff header to the addresses registered in a local file, for functions
+// deployed outside of Kubernetes.
+// The file is checked for changes periodically: once it parses, the new registry replaces the former one at once.
+// In-flight invocations carry on with the connections they already got. Invalid registries are ignored, until fixed.
+type RegistryResolver struct {
+	// logs reloads and reload failures, if set
+	Logger   *Logger
+	path     string
+	interval time.Duration
+	// current registryTable
+	table     atomic.Value
+	content   []byte
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type registryTable map[string]*registryEntry
+
+// Reads the registry from the given file, then watches it for changes every reload interval, which defaults to 1 second.
+func NewRegistryResolver(path string, reloadInterval time.Duration) (*RegistryResolver, error) {
+	if reloadInterval <= 0 {
+		reloadInterval = defaultReloadInterval
+	}
+	resolver := &RegistryResolver{path: path, interval: reloadInterval, stop: make(chan struct{}), done: make(chan struct{})}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table, err := parseRegistry(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	resolver.content = content
+	resolver.table.Store(table)
+	go resolver.watch()
+	return resolver, nil
+}
+
+func parseRegistry(content []byte) (registryTable, error) {
+	var file registryFile
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(file.Functions))
+	for name := range file.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	table := make(registryTable, len(names))
+	for _, name := range names {
+		function := file.Functions[name]
+		entry := &registryEntry{authority: function.Authority}
+		for _, address := range function.Addresses {
+			if address == "" {
+				return nil, fmt.Errorf("function %q: expected addresses not to be empty", name)
+			}
+			entry.addresses = append(entry.addresses, address)
+		}
+		if len(entry.addresses) == 0 {
+			return nil, fmt.Errorf("function %q: addresses are missing", name)
+		}
+		table[name] = entry
+	}
+	return table, nil
+}
+
+func (resolver *RegistryResolver) Resolve(request *http.Request) (*grpc.ClientConn, error) {
+	return dialTarget(resolver, request)
+}
+
+func (resolver *RegistryResolver) ResolveTarget(request *http.Request) (Target, error) {
+	name, err := functionName(request)
+	if err != nil {
+		return Target{}, err
+	}
+	entry, found := resolver.table.Load().(registryTable)[name]
+	if !found {
+		return Target{}, unknownFunction(fmt.Sprintf("%q is not registered", name))
+	}
+	matchFunction(request, name)
+	authority := entry.authority
+	if authority == "" {
+		authority = request.Header.Get("X-Riff-Authority")
+	}
+	index := (atomic.AddUint32(&entry.next, 1) - 1) % uint32(len(entry.addresses))
+	return Target{Address: entry.addresses[index], Authority: authority}, nil
+}
+
+// Stops watching the registry file
+func (resolver *RegistryResolver) Close() error {
+	resolver.closeOnce.Do(func() {
+		close(resolver.stop)
+	})
+	<-resolver.done
+	return nil
+}
+
+func (resolver *RegistryResolver) watch() {
+	defer close(resolver.done)
+	ticker := time.NewTicker(resolver.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-resolver.stop:
+			return
+		case <-ticker.C:
+			resolver.reload()
+		}
+	}
+}
+
+// The file may be replaced rather than written in place, hence the comparison of contents rather than modification times
+func (resolver *RegistryResolver) reload() {
+	content, err := ioutil.ReadFile(resolver.path)
+	if err != nil {
+		resolver.Logger.Warn("error when reading the service registry", "path", resolver.path, "error", err)
+		return
+	}
+	if bytes.Equal(content, resolver.content) {
+		return
+	}
+	resolver.content = content
+	table, err := parseRegistry(content)
+	if err != nil {
+		resolver.Logger.Warn("invalid service registry, keeping the former one", "path", resolver.path, "error", err)
+		return
+	}
+	resolver.table.Store(table)
+	resolver.Logger.Info("reloaded the service registry", "path", resolver.path, "functions", len(table))
+}
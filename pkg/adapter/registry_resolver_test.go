@@ -0,0 +1,138 @@
+package adapter_test
+
+import (
+	"bufio"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"riff-streaming-adapter/pkg/adapter"
+	"time"
+)
+
+var _ = Describe("Registry resolver", func() {
+
+	var (
+		directory string
+		path      string
+		resolver  *adapter.RegistryResolver
+	)
+
+	writeRegistry := func(content string) {
+		// replaced rather than written in place, as editors and configuration management tools do
+		temporaryPath := path + ".tmp"
+		Expect(ioutil.WriteFile(temporaryPath, []byte(content), 0644)).To(Succeed())
+		Expect(os.Rename(temporaryPath, path)).To(Succeed())
+	}
+
+	request := func(function string) *http.Request {
+		return &http.Request{Header: http.Header{"X-Riff": {function}, "X-Riff-Authority": {function + ".example.com"}}}
+	}
+
+	resolveAddress := func(function string) func() (string, error) {
+		return func() (string, error) {
+			target, err := resolver.ResolveTarget(request(function))
+			return target.Address, err
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "registry")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(directory, "registry.yaml")
+		writeRegistry(`
+functions:
+  square:
+    addresses: [10.0.0.1:8081, 10.0.0.2:8081]
+  cube:
+    addresses: [10.0.0.3:8081]
+    authority: cube.internal
+`)
+		resolver, err = adapter.NewRegistryResolver(path, 10*time.Millisecond)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		assertClose(resolver)
+		Expect(os.RemoveAll(directory)).To(Succeed())
+	})
+
+	It("resolves the registered addresses in turn", func() {
+		Expect(resolver.ResolveTarget(request("square"))).To(Equal(adapter.Target{Address: "10.0.0.1:8081", Authority: "square.example.com"}))
+		Expect(resolver.ResolveTarget(request("square"))).To(Equal(adapter.Target{Address: "10.0.0.2:8081", Authority: "square.example.com"}))
+		Expect(resolver.ResolveTarget(request("square"))).To(Equal(adapter.Target{Address: "10.0.0.1:8081", Authority: "square.example.com"}))
+		Expect(resolver.ResolveTarget(request("cube"))).To(Equal(adapter.Target{Address: "10.0.0.3:8081", Authority: "cube.internal"}))
+	})
+
+	It("fails to resolve unregistered functions", func() {
+		_, err := resolver.ResolveTarget(request("sqrt"))
+
+		Expect(err).To(MatchError(`"sqrt" is not registered`))
+		Expect(err.(*adapter.Problem).Status).To(Equal(404))
+	})
+
+	It("fails when header is missing", func() {
+		_, err := resolver.ResolveTarget(&http.Request{Header: http.Header{}})
+
+		Expect(err).To(MatchError(`"X-Riff" header is missing`))
+	})
+
+	It("reloads the registry when the file changes", func() {
+		logs := gbytes.NewBuffer()
+		resolver.Logger = adapter.NewLogger(logs, adapter.InfoLevel, adapter.LogfmtFormat)
+
+		writeRegistry(`{"functions": {"sqrt": {"addresses": ["10.0.0.4:8081"]}}}`)
+
+		Eventually(resolveAddress("sqrt")).Should(Equal("10.0.0.4:8081"))
+		Eventually(logs).Should(gbytes.Say(`level=info msg="reloaded the service registry" path=.*registry.yaml functions=1`))
+		_, err := resolver.ResolveTarget(request("square"))
+		Expect(err).To(MatchError(`"square" is not registered`))
+	})
+
+	It("keeps the former registry while the file is invalid", func() {
+		logs := gbytes.NewBuffer()
+		resolver.Logger = adapter.NewLogger(logs, adapter.InfoLevel, adapter.LogfmtFormat)
+
+		writeRegistry("functions: {square: {addresses: []}}")
+
+		Eventually(logs).Should(gbytes.Say(`level=warn msg="invalid service registry, keeping the former one" .*error="function \\"square\\": addresses are missing"`))
+		Expect(resolveAddress("cube")()).To(Equal("10.0.0.3:8081"))
+
+		writeRegistry("functions: {square: {addresses: [10.0.0.5:8081]}}")
+
+		Eventually(resolveAddress("square")).Should(Equal("10.0.0.5:8081"))
+	})
+
+	It("rejects invalid registries", func() {
+		writeRegistry("functions: {square: {address: 10.0.0.1:8081}}")
+
+		_, err := adapter.NewRegistryResolver(path, time.Minute)
+
+		Expect(err).To(MatchError(ContainSubstring(`field address not found`)))
+	})
+
+	It("lets in-flight invocations complete across reloads", func() {
+		grpcConnection, grpcAddress := openGrpcConnection(NewTickerServer(10 * time.Millisecond))
+		defer assertClose(grpcConnection)
+		writeRegistry(fmt.Sprintf("functions: {ticker: {addresses: [%q]}}", grpcAddress))
+		Eventually(resolveAddress("ticker")).Should(Equal(grpcAddress))
+		_, adapterAddress, stop := startPooledAdapter(resolver)
+		defer stop()
+		response, err := http.DefaultClient.Do(post(adapterAddress, map[string]string{"X-Riff": "ticker"}, ""))
+		Expect(err).NotTo(HaveOccurred())
+		defer assertClose(response.Body)
+		reader := bufio.NewReader(response.Body)
+		Expect(reader.ReadString('\n')).To(Equal("tick\n"))
+
+		writeRegistry("functions: {ticker: {addresses: [10.0.0.6:8081]}}")
+		Eventually(resolveAddress("ticker")).Should(Equal("10.0.0.6:8081"))
+
+		Expect(reader.ReadString('\n')).To(HaveSuffix("tick\n"))
+		Expect(reader.ReadString('\n')).To(HaveSuffix("tick\n"))
+	})
+})
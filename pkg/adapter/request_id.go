@@ -0,0 +1,60 @@
+package adapter
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+)
+
+// Header identifying a request, across the client, the adapter and the function
+const RequestIdHeader = "X-Request-Id"
+
+// Longer client IDs are replaced by generated ones
+const maxRequestIdLength = 200
+
+// Makes sure every request served by next is identified: the client request ID is kept if valid, a random UUID is
+// generated otherwise. The ID is set as the X-Request-Id header of both the request and the response.
+func identifyRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		requestId := request.Header.Get(RequestIdHeader)
+		if !isValidRequestId(requestId) {
+			requestId = newRequestId()
+		}
+		request.Header.Set(RequestIdHeader, requestId)
+		responseWriter.Header().Set(RequestIdHeader, requestId)
+		next.ServeHTTP(responseWriter, request)
+	})
+}
+
+// IDs are made of at most maxRequestIdLength visible ASCII characters, so that they can be logged as is
+func isValidRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(requestId); i++ {
+		if requestId[i] <= ' ' || requestId[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Random (version 4) UUID
+func newRequestId() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// Adds the ID of the request, if any, to the outgoing gRPC metadata
+func injectRequestIdMetadata(ctx context.Context, request *http.Request) context.Context {
+	requestId := request.Header.Get(RequestIdHeader)
+	if requestId == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "x-request-id", requestId)
+}
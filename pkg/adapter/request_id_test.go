@@ -0,0 +1,90 @@
+package adapter_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"net/http"
+	"riff-streaming-adapter/pkg/adapter"
+	"strings"
+	"time"
+)
+
+var _ = Describe("Request IDs", func() {
+
+	const uuidPattern = `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`
+
+	var (
+		server           *echoServer
+		grpcConnection   *grpc.ClientConn
+		streamingAdapter *adapter.StreamingAdapter
+		adapterAddress   string
+		httpClient       *http.Client
+	)
+
+	BeforeEach(func() {
+		var grpcAddress string
+		server = NewEchoServer()
+		grpcConnection, grpcAddress = openGrpcConnection(server)
+		httpPort := findFreePort()
+		streamingAdapter = &adapter.StreamingAdapter{
+			ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+			Timeout:         time.Minute,
+		}
+		Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+		adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+		httpClient = &http.Client{}
+	})
+
+	AfterEach(func() {
+		assertClose(streamingAdapter)
+		assertClose(grpcConnection)
+	})
+
+	It("propagates the client request ID to the function", func() {
+		response, err := httpClient.Do(post(adapterAddress, map[string]string{"X-Request-Id": "some-id"}, "hello"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(200))
+		Expect(response.Header.Get("X-Request-Id")).To(Equal("some-id"))
+		Expect(asString(response.Body)).To(Equal("hello"))
+		Expect(server.Headers()[0]["X-Request-Id"]).To(Equal("some-id"))
+		Expect(server.Metadata().Get("x-request-id")).To(Equal([]string{"some-id"}))
+	})
+
+	It("generates request IDs", func() {
+		response, err := httpClient.Do(post(adapterAddress, nil, "hello"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(200))
+		requestId := response.Header.Get("X-Request-Id")
+		Expect(requestId).To(MatchRegexp(uuidPattern))
+		assertClose(response.Body)
+		Expect(server.Headers()[0]["X-Request-Id"]).To(Equal(requestId))
+		Expect(server.Metadata().Get("x-request-id")).To(Equal([]string{requestId}))
+	})
+
+	It("replaces invalid request IDs", func() {
+		for _, invalidId := range []string{"some id", strings.Repeat("a", 201)} {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{"X-Request-Id": invalidId}, "hello"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.Header.Get("X-Request-Id")).To(MatchRegexp(uuidPattern), invalidId)
+			assertClose(response.Body)
+		}
+	})
+
+	It("returns request IDs along with problems", func() {
+		response, err := httpClient.Do(post(adapterAddress, map[string]string{
+			"Accept":              "application/problem+json",
+			adapter.TimeoutHeader: "oops",
+		}, "hello"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(400))
+		requestId := response.Header.Get("X-Request-Id")
+		Expect(requestId).To(MatchRegexp(uuidPattern))
+		Expect(asProblem(response.Body).RequestId).To(Equal(requestId))
+	})
+})
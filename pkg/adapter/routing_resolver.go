@@ -0,0 +1,149 @@
+package adapter
+
+import (
+	"fmt"
+	"google.golang.org/grpc"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Requests a route applies to, along with the gRPC server backing them
+type Route struct {
+	// exact host names, e.g. "api.example.com", or wildcards matching their subdomains, e.g. "*.example.com".
+	// Ports are ignored. Routes without hosts match any host.
+	Hosts []string
+	// matched on path segment boundaries: "/orders" matches "/orders" and "/orders/validate", but not "/orderspace".
+	// Routes without path prefix match any path.
+	PathPrefix string
+	// Routes without methods match any method
+	Methods []string
+	Target  Target
+	// name labelling the metrics of the requests the route matches, defaults to the target address
+	Function string
+}
+
+// ServiceResolver routing requests according to their host, path and method, without requiring an X-Riff header.
+// When several routes match a request, the most specific one wins: an exact host beats a wildcard, which beats any
+// host, longer wildcards beat shorter ones, then longer path prefixes beat shorter ones, then routes restricted to
+// some methods beat the others. Remaining ties go to the first declared route.
+type RoutingResolver struct {
+	// sorted by decreasing specificity
+	entries []routeEntry
+}
+
+// Route for a single host pattern
+type routeEntry struct {
+	// exact host, wildcard suffix such as ".example.com", or empty for any host
+	host       string
+	wildcard   bool
+	pathPrefix string
+	methods    map[string]bool
+	target     Target
+	function   string
+}
+
+func NewRoutingResolver(routes []Route) (*RoutingResolver, error) {
+	var entries []routeEntry
+	for i, route := range routes {
+		if route.Target.Address == "" {
+			return nil, fmt.Errorf("route %d: target address is missing", i)
+		}
+		if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+			return nil, fmt.Errorf("route %d: %q is invalid: expected path prefix to start with /", i, route.PathPrefix)
+		}
+		var methods map[string]bool
+		for _, method := range route.Methods {
+			if methods == nil {
+				methods = make(map[string]bool)
+			}
+			methods[strings.ToUpper(method)] = true
+		}
+		function := route.Function
+		if function == "" {
+			function = route.Target.Address
+		}
+		entry := routeEntry{pathPrefix: strings.TrimSuffix(route.PathPrefix, "/"), methods: methods, target: route.Target, function: function}
+		if len(route.Hosts) == 0 {
+			entries = append(entries, entry)
+			continue
+		}
+		for _, host := range route.Hosts {
+			host = strings.ToLower(strings.TrimSuffix(host, "."))
+			if strings.HasPrefix(host, "*.") {
+				entry.host, entry.wildcard = host[1:], true
+			} else {
+				entry.host, entry.wildcard = host, false
+			}
+			if entry.host == "" || strings.Contains(entry.host, "*") {
+				return nil, fmt.Errorf("route %d: %q is invalid: expected a host name, possibly prefixed by *.", i, host)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].moreSpecificThan(entries[j])
+	})
+	return &RoutingResolver{entries: entries}, nil
+}
+
+func (entry routeEntry) moreSpecificThan(other routeEntry) bool {
+	if rank, otherRank := entry.hostRank(), other.hostRank(); rank != otherRank {
+		return rank > otherRank
+	}
+	if len(entry.host) != len(other.host) {
+		return len(entry.host) > len(other.host)
+	}
+	if len(entry.pathPrefix) != len(other.pathPrefix) {
+		return len(entry.pathPrefix) > len(other.pathPrefix)
+	}
+	return len(entry.methods) > 0 && len(other.methods) == 0
+}
+
+func (entry routeEntry) hostRank() int {
+	switch {
+	case entry.host == "":
+		return 0
+	case entry.wildcard:
+		return 1
+	}
+	return 2
+}
+
+func (entry routeEntry) matches(host string, path string, method string) bool {
+	if entry.wildcard && !strings.HasSuffix(host, entry.host) {
+		return false
+	}
+	if !entry.wildcard && entry.host != "" && entry.host != host {
+		return false
+	}
+	if entry.pathPrefix != "" && path != entry.pathPrefix && !strings.HasPrefix(path, entry.pathPrefix+"/") {
+		return false
+	}
+	return entry.methods == nil || entry.methods[method]
+}
+
+func (resolver *RoutingResolver) Resolve(request *http.Request) (*grpc.ClientConn, error) {
+	return dialTarget(resolver, request)
+}
+
+func (resolver *RoutingResolver) ResolveTarget(request *http.Request) (Target, error) {
+	host := requestHost(request)
+	for _, entry := range resolver.entries {
+		if entry.matches(host, request.URL.Path, request.Method) {
+			matchFunction(request, entry.function)
+			return entry.target, nil
+		}
+	}
+	return Target{}, noMatchingRoute(fmt.Sprintf("no route matches %s %s%s", request.Method, host, request.URL.Path))
+}
+
+// Lowercase host of the request, without port nor trailing dot
+func requestHost(request *http.Request) string {
+	host := request.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
@@ -0,0 +1,93 @@
+package adapter_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"riff-streaming-adapter/pkg/adapter"
+)
+
+var _ = Describe("Routing resolver", func() {
+
+	target := func(address string) adapter.Target {
+		return adapter.Target{Address: address, Authority: address + ".example.com"}
+	}
+
+	var resolver *adapter.RoutingResolver
+
+	BeforeEach(func() {
+		var err error
+		resolver, err = adapter.NewRoutingResolver([]adapter.Route{
+			{Target: target("fallback")},
+			{PathPrefix: "/orders", Target: target("orders")},
+			{PathPrefix: "/orders/validate/", Methods: []string{"post"}, Target: target("validator")},
+			{PathPrefix: "/orders/validate", Target: target("validator-reader")},
+			{Hosts: []string{"*.example.com"}, Target: target("wildcard")},
+			{Hosts: []string{"*.eu.example.com"}, Target: target("eu-wildcard")},
+			{Hosts: []string{"api.example.com", "API.example.org."}, PathPrefix: "/", Target: target("api")},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	DescribeTable("picks the most specific matching route",
+		func(method string, url string, expectedTarget string) {
+			resolved, err := resolver.ResolveTarget(httptest.NewRequest(method, url, nil))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resolved).To(Equal(target(expectedTarget)))
+		},
+		Entry("any route", "GET", "http://localhost/", "fallback"),
+		Entry("path prefix", "GET", "http://localhost/orders/42", "orders"),
+		Entry("exact path", "GET", "http://localhost/orders", "orders"),
+		Entry("path segment boundary", "GET", "http://localhost/orderspace", "fallback"),
+		Entry("longest path prefix, restricted methods", "POST", "http://localhost/orders/validate", "validator"),
+		Entry("longest path prefix, any method", "GET", "http://localhost/orders/validate", "validator-reader"),
+		Entry("wildcard host", "GET", "http://shop.example.com/orders", "wildcard"),
+		Entry("longest wildcard host", "GET", "http://shop.eu.example.com/", "eu-wildcard"),
+		Entry("wildcard excluding the domain itself", "GET", "http://example.com/orders", "orders"),
+		Entry("exact host, ignoring port", "GET", "http://api.example.com:8080/orders", "api"),
+		Entry("exact host, ignoring case", "GET", "http://api.EXAMPLE.org/", "api"),
+	)
+
+	It("fails when no route matches", func() {
+		resolver, err := adapter.NewRoutingResolver([]adapter.Route{{Methods: []string{"GET"}, Target: target("reader")}})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = resolver.ResolveTarget(httptest.NewRequest("POST", "http://localhost/orders", nil))
+
+		Expect(err).To(MatchError("no route matches POST localhost/orders"))
+		Expect(err.(*adapter.Problem).Status).To(Equal(404))
+	})
+
+	DescribeTable("rejects invalid routes",
+		func(route adapter.Route, expectedError string) {
+			_, err := adapter.NewRoutingResolver([]adapter.Route{{Target: target("valid")}, route})
+
+			Expect(err).To(MatchError(expectedError))
+		},
+		Entry("missing target", adapter.Route{PathPrefix: "/"}, "route 1: target address is missing"),
+		Entry("relative path prefix", adapter.Route{PathPrefix: "orders", Target: target("orders")}, `route 1: "orders" is invalid: expected path prefix to start with /`),
+		Entry("inner wildcard", adapter.Route{Hosts: []string{"api.*.com"}, Target: target("api")}, `route 1: "api.*.com" is invalid: expected a host name, possibly prefixed by *.`),
+	)
+
+	It("routes invocations without X-Riff header", func() {
+		grpcConnection, grpcAddress := openGrpcConnection(NewFrenchizerServer())
+		defer assertClose(grpcConnection)
+		resolver, err := adapter.NewRoutingResolver([]adapter.Route{{PathPrefix: "/numbers", Methods: []string{"POST"}, Target: adapter.Target{Address: grpcAddress}}})
+		Expect(err).NotTo(HaveOccurred())
+		_, adapterAddress, stop := startPooledAdapter(resolver)
+		defer stop()
+
+		response, err := http.DefaultClient.Do(post(adapterAddress+"/numbers/french", map[string]string{"Accept": "text/plain"}, "1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(200))
+		Expect(asString(response.Body)).To(Equal("un"))
+
+		response, err = http.DefaultClient.Do(post(adapterAddress+"/letters", map[string]string{"Accept": "application/json"}, "a"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(404))
+		Expect(asProblem(response.Body).Type).To(Equal("urn:riff:streaming-adapter:problem:no-matching-route"))
+	})
+})
@@ -11,28 +11,89 @@ type ServiceResolver interface {
 	Resolve(request *http.Request) (*grpc.ClientConn, error)
 }
 
-type KnativeServiceResolver struct{}
+// ServiceResolver managing the lifecycle of the connections it resolves.
+// Connections are handed back to it once invocations complete, instead of being closed.
+type ConnectionReleaser interface {
+	Release(connection *grpc.ClientConn)
+}
+
+// Coordinates of the gRPC server backing a function
+type Target struct {
+	Address   string
+	Authority string
+}
+
+func (target Target) dial() (*grpc.ClientConn, error) {
+	return grpc.Dial(target.Address, grpc.WithInsecure(), grpc.WithAuthority(target.Authority))
+}
+
+// Resolves the gRPC server of a function without connecting to it
+type TargetResolver interface {
+	ResolveTarget(request *http.Request) (Target, error)
+}
+
+// Dials the target the resolver resolves the request to, for resolvers to implement ServiceResolver
+func dialTarget(resolver TargetResolver, request *http.Request) (*grpc.ClientConn, error) {
+	target, err := resolver.ResolveTarget(request)
+	if err != nil {
+		return nil, err
+	}
+	return target.dial()
+}
 
-func (*KnativeServiceResolver) Resolve(request *http.Request) (*grpc.ClientConn, error) {
+// Name of the invoked function, set in the X-Riff header
+func functionName(request *http.Request) (string, error) {
 	name := request.Header.Get("X-Riff")
 	if name == "" {
-		return nil, fmt.Errorf("%q header is missing", "X-Riff")
+		return "", missingFunctionName(fmt.Sprintf("%q header is missing", "X-Riff"))
+	}
+	return name, nil
+}
+
+const defaultClusterDomain = "cluster.local"
+
+// Resolves the Kubernetes service named SERVICE_NAME/NAMESPACE in the X-Riff header
+type KnativeServiceResolver struct {
+	// defaults to "cluster.local"
+	ClusterDomain string
+}
+
+func (resolver *KnativeServiceResolver) Resolve(request *http.Request) (*grpc.ClientConn, error) {
+	return dialTarget(resolver, request)
+}
+
+func (resolver *KnativeServiceResolver) ResolveTarget(request *http.Request) (Target, error) {
+	name, err := functionName(request)
+	if err != nil {
+		return Target{}, err
 	}
 	coordinates := strings.SplitN(name, "/", 2)
 	if len(coordinates) != 2 {
-		return nil, fmt.Errorf("%q is invalid: expected name to follow SERVICE_NAME/NAMESPACE structure", name)
+		return Target{}, invalidFunctionName(fmt.Sprintf("%q is invalid: expected name to follow SERVICE_NAME/NAMESPACE structure", name))
 	}
-	host := fmt.Sprintf("%s.%s.svc.cluster.local", coordinates[0], coordinates[1])
+	host := serviceHost(coordinates[0], coordinates[1], resolver.ClusterDomain)
+	matchFunction(request, name)
 
-	return grpc.Dial(host, grpc.WithInsecure(), grpc.WithAuthority(request.Header.Get("X-Riff-Authority")))
+	return Target{Address: host, Authority: request.Header.Get("X-Riff-Authority")}, nil
+}
+
+func serviceHost(name string, namespace string, clusterDomain string) string {
+	if clusterDomain == "" {
+		clusterDomain = defaultClusterDomain
+	}
+	return fmt.Sprintf("%s.%s.svc.%s", name, namespace, clusterDomain)
 }
 
 type PassthroughResolver struct{}
 
-func (*PassthroughResolver) Resolve(request *http.Request) (*grpc.ClientConn, error) {
-	host := request.Header.Get("X-Riff")
-	if host == "" {
-		return nil, fmt.Errorf("%q header is missing", "X-Riff")
+func (resolver *PassthroughResolver) Resolve(request *http.Request) (*grpc.ClientConn, error) {
+	return dialTarget(resolver, request)
+}
+
+func (*PassthroughResolver) ResolveTarget(request *http.Request) (Target, error) {
+	host, err := functionName(request)
+	if err != nil {
+		return Target{}, err
 	}
-	return grpc.Dial(host, grpc.WithInsecure(), grpc.WithAuthority(request.Header.Get("X-Riff-Authority")))
+	return Target{Address: host, Authority: request.Header.Get("X-Riff-Authority")}, nil
 }
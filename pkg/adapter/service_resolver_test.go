@@ -2,8 +2,10 @@ package adapter_test
 
 import (
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"net/http"
+	"net/http/httptest"
 	"riff-streaming-adapter/pkg/adapter"
 )
 
@@ -21,6 +23,27 @@ var _ = Describe("Service resolver", func() {
 		Expect(connection).NotTo(BeNil())
 	})
 
+	It("resolves Knative service targets", func() {
+		resolver := &adapter.KnativeServiceResolver{}
+
+		target, err := resolver.ResolveTarget(&http.Request{Header: http.Header{
+			"X-Riff":           {"square/default"},
+			"X-Riff-Authority": {"square.default.example.com"},
+		}})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal(adapter.Target{Address: "square.default.svc.cluster.local", Authority: "square.default.example.com"}))
+	})
+
+	It("resolves Knative service targets of custom cluster domains", func() {
+		resolver := &adapter.KnativeServiceResolver{ClusterDomain: "cluster.example"}
+
+		target, err := resolver.ResolveTarget(&http.Request{Header: http.Header{"X-Riff": {"square/default"}}})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target.Address).To(Equal("square.default.svc.cluster.example"))
+	})
+
 	It("fails to resolve unstructured names", func() {
 		resolver := &adapter.KnativeServiceResolver{}
 
@@ -36,4 +59,46 @@ var _ = Describe("Service resolver", func() {
 
 		Expect(err).To(MatchError("\"X-Riff\" header is missing"))
 	})
+
+	Describe("from the Host header", func() {
+
+		DescribeTable("resolves Knative service targets",
+			func(domainTemplate string, domain string, clusterDomain string, url string, expectedTarget adapter.Target) {
+				resolver, err := adapter.NewKnativeHostResolver(domainTemplate, domain, clusterDomain)
+				Expect(err).NotTo(HaveOccurred())
+
+				target, err := resolver.ResolveTarget(httptest.NewRequest("POST", url, nil))
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(target).To(Equal(expectedTarget))
+			},
+			Entry("default template", "", "example.com", "", "http://square.default.example.com/",
+				adapter.Target{Address: "square.default.svc.cluster.local", Authority: "square.default.example.com"}),
+			Entry("any domain, ignoring port and case", "", "", "", "http://Square.Default.Example.org:8080/",
+				adapter.Target{Address: "square.default.svc.cluster.local", Authority: "square.default.example.org"}),
+			Entry("custom template and cluster domain", "{{.Namespace}}-{{.Name}}.fn.{{.Domain}}", "example.com", "cluster.example", "http://my-team-square.fn.example.com/",
+				adapter.Target{Address: "square.my-team.svc.cluster.example", Authority: "my-team-square.fn.example.com"}),
+		)
+
+		It("fails to resolve hosts not following the template", func() {
+			resolver, err := adapter.NewKnativeHostResolver("", "example.com", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = resolver.ResolveTarget(httptest.NewRequest("POST", "http://square.default.example.org/", nil))
+
+			Expect(err).To(MatchError(`"square.default.example.org" is invalid: expected host to follow {{.Name}}.{{.Namespace}}.{{.Domain}}`))
+			Expect(err.(*adapter.Problem).Status).To(Equal(404))
+		})
+
+		DescribeTable("rejects invalid templates",
+			func(domainTemplate string, expectedError string) {
+				_, err := adapter.NewKnativeHostResolver(domainTemplate, "example.com", "")
+
+				Expect(err).To(MatchError(ContainSubstring(expectedError)))
+			},
+			Entry("syntax error", "{{.Name}.{{.Namespace}}", `"{{.Name}.{{.Namespace}}" is invalid: template: domain:1`),
+			Entry("missing namespace", "{{.Name}}.{{.Domain}}", `"{{.Name}}.{{.Domain}}" is invalid: expected the template to reference both {{.Name}} and {{.Namespace}}`),
+			Entry("unknown field", "{{.Name}}.{{.Namespace}}.{{.Zone}}", `executing "domain" at <.Zone>`),
+		)
+	})
 })
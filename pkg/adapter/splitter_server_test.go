@@ -0,0 +1,41 @@
+package adapter_test // visible for tests only
+
+import (
+	"fmt"
+	"io"
+	"riff-streaming-adapter/streaming"
+	"strings"
+)
+
+// gRPC server that splits the payload of every received NEXT signal around commas and emits one NEXT signal per part,
+// terminated by a newline.
+// It fails the invocation when encountering the "boom" part, after having emitted the previous parts.
+type splitterServer struct{}
+
+func NewSplitterServer() *splitterServer {
+	return &splitterServer{}
+}
+
+func (*splitterServer) Invoke(server streaming.Riff_InvokeServer) error {
+	for {
+		signal, err := server.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		value := signal.GetNext()
+		if value == nil {
+			continue
+		}
+		for _, part := range strings.Split(string(value.Payload), ",") {
+			if part == "boom" {
+				return fmt.Errorf("boom")
+			}
+			if err := server.Send(nextSignal(part + "\n")); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -2,26 +2,77 @@ package adapter
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"io/ioutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"io"
+	"mime"
 	"net"
 	"net/http"
 	"os"
 	"riff-streaming-adapter/streaming"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	defaultHeartbeatInterval = 15 * time.Second
+	// how often Shutdown checks whether invocations are still in progress
+	shutdownPollInterval = 50 * time.Millisecond
+)
+
 // visible for tests
 type StreamingAdapter struct {
 	ServiceResolver ServiceResolver
 	Timeout         time.Duration
-	server          http.Server
+	// maximum duration of WebSocket and server-sent event streams, which requested timeouts are capped to,
+	// unlimited if not strictly positive
+	StreamTimeout time.Duration
+	// defaults to WholeBodySplitter, i.e. a single Next signal per request
+	BodySplitter BodySplitter
+	// interval between two server-sent event heartbeats, defaults to 15s
+	HeartbeatInterval time.Duration
+	// port exclusively serving the health and metrics endpoints, which are otherwise served by the main port
+	ManagementPort int
+	// prefix of the health and metrics endpoint paths, e.g. "/_riff" for "/_riff/healthz", "/_riff/readyz" and "/_riff/metrics"
+	ManagementPathPrefix string
+	// gRPC servers that must be serving, according to the gRPC health-checking protocol, for the adapter to be ready
+	ReadinessTargets []Target
+	// traces invocations, if set
+	Tracer *Tracer
+	// logs invocations and errors, if set
+	Logger *Logger
+	// maximum size of the request bodies, unlimited if not strictly positive
+	MaxRequestBodySize int64
+	// maximum size of the request headers, defaults to http.DefaultMaxHeaderBytes
+	MaxHeaderBytes int
+	// maximum size of the lines of newline-delimited JSON request bodies, defaults to 1MiB
+	MaxFrameSize int
+	// origins, e.g. "https://app.example.com", allowed to open WebSocket connections besides the adapter host, "*" for any
+	WebSocketOrigins []string
+	// serves the main port over TLS, if set
+	TlsConfig        *tls.Config
+	server           http.Server
+	managementServer http.Server
+	metrics          *adapterMetrics
+	// set to 1 once Shutdown is called
+	draining int32
+	// count of the invocations in progress
+	invocations int64
+	// closed when Shutdown gives up waiting, so that the remaining invocations are cancelled
+	terminated    chan struct{}
+	terminateOnce sync.Once
 }
 
 func NewStreamingAdapter(timeout time.Duration) *StreamingAdapter {
 	return &StreamingAdapter{
 		ServiceResolver: &PassthroughResolver{},
 		Timeout:         timeout,
+		BodySplitter:    WholeBodySplitter{},
+		Logger:          NewLogger(os.Stderr, InfoLevel, JsonFormat),
 	}
 }
 
@@ -30,21 +81,113 @@ func (adapter *StreamingAdapter) Start(httpPort int) error {
 	if err != nil {
 		return err
 	}
-	adapter.server = http.Server{Handler: &AdapterHttpHandler{
+	tracker := &trackingListener{Listener: listener}
+	listener = tracker
+	if adapter.TlsConfig != nil {
+		listener = tls.NewListener(listener, adapter.TlsConfig)
+	}
+	var managementListener net.Listener
+	if adapter.ManagementPort > 0 {
+		if managementListener, err = net.Listen("tcp", fmt.Sprintf(":%d", adapter.ManagementPort)); err != nil {
+			_ = listener.Close()
+			return err
+		}
+	}
+	adapter.terminated = make(chan struct{})
+	adapter.metrics = newAdapterMetrics()
+	adapter.metrics.registerResolver(adapter.ServiceResolver)
+	var handler http.Handler = &AdapterHttpHandler{
 		ServiceResolver: adapter.ServiceResolver,
 		timeout:         adapter.Timeout,
-	}}
-	go func() {
-		if err = adapter.server.Serve(listener); err != nil && err != http.ErrServerClosed {
-			_, _ = fmt.Fprintf(os.Stderr, "error when starting server: %v", err)
-		}
-	}()
+		streamTimeout:   adapter.StreamTimeout,
+		bodySplitter:    adapter.BodySplitter,
+		heartbeat:       adapter.HeartbeatInterval,
+		maxBodySize:     adapter.MaxRequestBodySize,
+		maxFrameSize:    adapter.MaxFrameSize,
+		origins:         adapter.WebSocketOrigins,
+		interruptReads:  tracker.interruptReads,
+		invocations:     &adapter.invocations,
+		terminated:      adapter.terminated,
+		metrics:         adapter.metrics,
+		logger:          adapter.Logger,
+	}
+	if adapter.Tracer != nil {
+		handler = adapter.Tracer.trace(handler)
+	}
+	if adapter.Logger != nil {
+		handler = adapter.Logger.logAccess(handler)
+	}
+	handler = adapter.metrics.instrument(handler)
+	management := &managementHandler{prefix: adapter.ManagementPathPrefix, adapter: adapter}
+	if managementListener != nil {
+		management.next = http.NotFoundHandler()
+		adapter.managementServer = http.Server{Handler: management}
+		go serve(&adapter.managementServer, managementListener, adapter.Logger)
+	} else {
+		management.next = handler
+		handler = management
+	}
+	adapter.server = http.Server{Handler: identifyRequests(handler), MaxHeaderBytes: adapter.MaxHeaderBytes}
+	go serve(&adapter.server, listener, adapter.Logger)
 	return nil
 }
 
+func serve(server *http.Server, listener net.Listener, logger *Logger) {
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		logger.Error("error when starting server", "address", listener.Addr(), "error", err)
+	}
+}
+
+// Abruptly closes the servers, invocations in progress are interrupted
 func (adapter *StreamingAdapter) Close() error {
-	if err := adapter.server.Close(); err != nil {
-		return err
+	err := adapter.server.Close()
+	if managementErr := adapter.managementServer.Close(); err == nil {
+		err = managementErr
+	}
+	return err
+}
+
+// Gracefully stops the server: new connections are refused and the adapter reports itself as not ready, while
+// invocations in progress, including WebSocket and server-sent event streams, are given until ctx is done to complete.
+// The remaining invocations are then cancelled, their gRPC streams torn down, and the error of ctx is returned.
+// The management server, if any, is closed last.
+func (adapter *StreamingAdapter) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&adapter.draining, 1)
+	defer func() {
+		_ = adapter.managementServer.Close()
+	}()
+	err := adapter.server.Shutdown(ctx)
+	if err == nil {
+		// hijacked connections, i.e. WebSocket ones, are not tracked by the server
+		err = adapter.awaitInvocations(ctx)
+	}
+	if err != nil {
+		adapter.Logger.Warn("cancelling the invocations still in flight after the grace period", "invocations", atomic.LoadInt64(&adapter.invocations))
+		adapter.terminateOnce.Do(func() {
+			if adapter.terminated != nil {
+				close(adapter.terminated)
+			}
+		})
+		_ = adapter.server.Close()
+		_ = adapter.awaitInvocations(context.Background())
+	}
+	return err
+}
+
+// Tells whether Shutdown has been called, in which case the adapter should not be sent new requests
+func (adapter *StreamingAdapter) Draining() bool {
+	return atomic.LoadInt32(&adapter.draining) == 1
+}
+
+func (adapter *StreamingAdapter) awaitInvocations(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&adapter.invocations) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
 	}
 	return nil
 }
@@ -53,92 +196,398 @@ func (adapter *StreamingAdapter) Close() error {
 type AdapterHttpHandler struct {
 	ServiceResolver ServiceResolver
 	timeout         time.Duration
+	// maximum duration of WebSocket and server-sent event streams, unlimited if not strictly positive
+	streamTimeout time.Duration
+	bodySplitter  BodySplitter
+	heartbeat     time.Duration
+	// maximum size of the request bodies, unlimited if not strictly positive
+	maxBodySize int64
+	// maximum size of the lines of newline-delimited JSON request bodies, defaults to 1MiB
+	maxFrameSize int
+	// origins allowed to open WebSocket connections besides the adapter host
+	origins []string
+	// fails the pending reads of the connection of the request, if set
+	interruptReads func(request *http.Request)
+	// count of the invocations in progress, maintained only when set
+	invocations *int64
+	// closed when the remaining invocations must be cancelled
+	terminated <-chan struct{}
+	// records the duration of the invocation phases, if set
+	metrics *adapterMetrics
+	// logs the errors that cannot be reported to the client, if set
+	logger *Logger
 }
 
 func (handler *AdapterHttpHandler) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
-	start, next, _ := convertRequest(request) // TODO: check error and return 500
+	if handler.invocations != nil {
+		atomic.AddInt64(handler.invocations, 1)
+		defer atomic.AddInt64(handler.invocations, -1)
+	}
+	started := time.Now()
+	logger := handler.invocationLogger(request)
+	if handler.maxBodySize > 0 {
+		if request.ContentLength > handler.maxBodySize {
+			reportProblem(logger, responseWriter, request, requestBodyTooLarge(handler.maxBodySize))
+			return
+		}
+		request.Body = &limitedBody{ReadCloser: request.Body, limit: handler.maxBodySize, remaining: handler.maxBodySize}
+	}
+	webSocket := isWebSocketUpgrade(request)
+	if webSocket && !allowsOrigin(request, handler.origins) {
+		reportProblem(logger, responseWriter, request, forbiddenOrigin(fmt.Sprintf("%q is not allowed", request.Header.Get("Origin"))))
+		return
+	}
+	resolveSpan := startChildSpan(request.Context(), resolvePhase, SpanKindInternal)
 	connection, err := handler.ServiceResolver.Resolve(request)
+	resolveSpan.endWith(err)
+	if err == nil {
+		handler.metrics.observeResolution(request, connection.Target())
+		logger = handler.invocationLogger(request)
+	}
+	handler.endPhase(request, resolvePhase, started)
 	if err != nil {
-		_ = writeError(responseWriter, 502, "unreachable gRPC server")
+		problem, ok := err.(*Problem)
+		if !ok {
+			problem = unreachableFunction(err)
+		}
+		if problem.Type == forbiddenTargetType {
+			handler.metrics.observeDenial()
+			logger.Info("denied function target", "detail", problem.Detail)
+		}
+		reportProblem(logger, responseWriter, request, problem)
 		return
 	}
-	defer func() {
-		_ = connection.Close()
-	}()
+	defer handler.release(connection, logger)
+	writer, accept := newFrameWriter(responseWriter, request)
+	eventStream, isEventStream := writer.(*eventStreamWriter)
+	ctx, cancel, err := handler.invocationContext(request, webSocket || isEventStream)
+	if err != nil {
+		reportProblem(logger, responseWriter, request, invalidTimeout(err.Error()))
+		return
+	}
+	defer cancel()
 	riffClient := streaming.NewRiffClient(connection)
-	client, err := riffClient.Invoke(context.Background())
+	dialSpan := startChildSpan(ctx, dialPhase, SpanKindClient)
+	client, err := riffClient.Invoke(injectTraceMetadata(injectRequestIdMetadata(ctx, request)))
+	dialSpan.endWith(err)
 	if err != nil {
-		_ = writeError(responseWriter, 502, "unreachable gRPC server")
+		reportProblem(logger, responseWriter, request, unreachableFunction(err))
 		return
 	}
-	serverResponse := make(chan *streaming.Signal, 1)
-	serverErrors := make(chan error, 1)
+	handler.endPhase(request, dialPhase, started)
+	var receiveErr error
+	receiveSpan := startChildSpan(ctx, receivePhase, SpanKindInternal)
+	defer func() {
+		receiveSpan.endWith(receiveErr)
+	}()
+	if webSocket {
+		serveWebSocket(client, cancel, responseWriter, request, logger)
+		return
+	}
+	requestErrors := make(chan error, 1)
+	body := &trackedBody{ReadCloser: request.Body}
+	request.Body = body
+	sent := make(chan struct{})
 	go func() {
-		// TODO: check errors and return 5xx (500? 503?)
-		_ = client.Send(start)
-		_ = client.Send(next)
-		_ = client.CloseSend() // TODO: adapt FrenchizerServer to exhibit the bug fixed by this line
-		signal, err := client.Recv()
+		defer close(sent)
+		sendSpan := startChildSpan(ctx, sendPhase, SpanKindInternal)
+		err := sendRequest(client, request, accept, handler.splitter(request), logger)
+		sendSpan.endWith(err)
 		if err != nil {
-			serverErrors <- err
-		} else {
-			serverResponse <- signal
+			requestErrors <- err
 		}
 	}()
+	defer handler.awaitSent(request, body, cancel, sent)
+	frames := make(chan *streaming.Next)
+	serverErrors := make(chan error, 1)
+	go receiveResponse(ctx, client, frames, serverErrors)
+
+	firstFrame := true
+	var heartbeat <-chan time.Time
+	if isEventStream {
+		ticker := time.NewTicker(handler.heartbeatInterval())
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		select {
+		case <-heartbeat:
+			if err := eventStream.heartbeat(); err != nil {
+				logger.Debug("error when writing heartbeat", "error", err)
+				return
+			}
+		case <-ctx.Done():
+			abortIfCommitted(writer)
+			reportProblem(logger, responseWriter, request, handler.interruptionProblem(ctx))
+			return
+		case err := <-requestErrors:
+			abortIfCommitted(writer)
+			problem, ok := err.(*Problem)
+			if !ok {
+				problem = unreadableRequestBody(err)
+			}
+			reportProblem(logger, responseWriter, request, problem)
+			return
+		case err := <-serverErrors:
+			receiveErr = err
+			abortIfCommitted(writer)
+			problem := statusProblem(status.Convert(err))
+			if ctx.Err() != nil {
+				// the stream was torn down by the adapter, not by the function
+				problem = handler.interruptionProblem(ctx)
+			}
+			reportProblem(logger, responseWriter, request, problem)
+			return
+		case next, open := <-frames:
+			if !open {
+				writer.close()
+				return
+			}
+			if firstFrame {
+				firstFrame = false
+				handler.endPhase(request, firstFramePhase, started)
+			}
+			if err := writer.write(next); err != nil {
+				if problem, ok := err.(*Problem); ok && !writer.isCommitted() {
+					reportProblem(logger, responseWriter, request, problem)
+					return
+				}
+				logger.Debug("error when writing frame", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// Logger of the entries logged during the invocation, carrying the same function as its metrics
+func (handler *AdapterHttpHandler) invocationLogger(request *http.Request) *Logger {
+	label := functionLabelOf(request)
+	keyValues := []interface{}{"function", label.value}
+	if label.target != "" {
+		keyValues = append(keyValues, "target", label.target)
+	}
+	return handler.logger.With(append(keyValues, "requestId", request.Header.Get(RequestIdHeader))...)
+}
+
+// Request bodies must not be read once ServeHTTP returns: the invocation is cancelled so that sends fail,
+// and the reads of bodies not read to the end, e.g. of stalled uploads, are interrupted.
+// Their connection is then closed rather than reused.
+func (handler *AdapterHttpHandler) awaitSent(request *http.Request, body *trackedBody, cancel context.CancelFunc, sent <-chan struct{}) {
 	select {
-	case <-time.After(handler.timeout):
-		_ = writeError(responseWriter, 504, "upstream gRPC server did not respond in time")
-	case err = <-serverErrors:
-		_ = writeError(responseWriter, 502, "misbehaving gRPC server")
-	case signal := <-serverResponse:
-		_ = writeResponse(responseWriter, signal.GetNext())
+	case <-sent:
+		return
+	default:
+	}
+	cancel()
+	if !body.isEnded() && handler.interruptReads != nil {
+		handler.interruptReads(request)
 	}
+	<-sent
 }
 
-func convertRequest(request *http.Request) (*streaming.Signal, *streaming.Signal, error) {
-	body, err := ioutil.ReadAll(request.Body)
-	if err != nil {
-		return nil, nil, err
+// Invocations are cancelled as soon as the client goes away, or when the adapter gives up shutting down gracefully.
+// They are also bound by the adapter timeout, except for WebSocket and server-sent event streams which are bound
+// by the stream timeout, if any. Clients may ask for a shorter timeout with TimeoutHeader.
+// The resulting deadline is propagated to the function.
+func (handler *AdapterHttpHandler) invocationContext(request *http.Request, stream bool) (context.Context, context.CancelFunc, error) {
+	timeout := handler.timeout
+	if stream {
+		timeout = handler.streamTimeout
 	}
-	err = request.Body.Close()
-	if err != nil {
-		return nil, nil, err
+	unbounded := timeout <= 0
+	if requestedTimeout := request.Header.Get(TimeoutHeader); requestedTimeout != "" {
+		parsedTimeout, err := parseTimeout(requestedTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		if unbounded || parsedTimeout < timeout {
+			timeout = parsedTimeout
+		}
+		unbounded = false
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if unbounded {
+		ctx, cancel = context.WithCancel(request.Context())
+	} else {
+		ctx, cancel = context.WithTimeout(request.Context(), timeout)
 	}
-	start := NewStartSignal(request.Header.Get("Accept"))
-	next := NewNextSignal(copyRequestHeaders(request.Header, "Accept"), body)
-	return start, next, nil
+	if handler.terminated != nil {
+		go func() {
+			select {
+			case <-handler.terminated:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel, nil
 }
 
-func copyRequestHeaders(headers http.Header, excludedHeader string) map[string]string {
-	result := make(map[string]string)
-	for key, values := range headers {
-		if key == excludedHeader {
+// Describes why the invocation context is done
+func (handler *AdapterHttpHandler) interruptionProblem(ctx context.Context) *Problem {
+	select {
+	case <-handler.terminated:
+		return shuttingDown()
+	default:
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return functionTimeout()
+	}
+	return clientClosedRequest()
+}
+
+// Forwards the Next signals emitted by the function until it completes the stream or the invocation is done
+func receiveResponse(ctx context.Context, client streaming.Riff_InvokeClient, frames chan<- *streaming.Next, errors chan<- error) {
+	for {
+		signal, err := client.Recv()
+		if err == io.EOF {
+			close(frames)
+			return
+		}
+		if err != nil {
+			errors <- err
+			return
+		}
+		next := signal.GetNext()
+		if next == nil {
 			continue
 		}
-		for _, value := range values {
-			result[key] = value
+		select {
+		case frames <- next:
+		case <-ctx.Done():
+			return
 		}
 	}
-	return result
 }
 
-func writeError(responseWriter http.ResponseWriter, statusCode int, reason string) error {
-	responseWriter.WriteHeader(statusCode)
-	responseWriter.Header().Add("Content-Type", "text/plain")
-	if _, err := responseWriter.Write([]byte(reason)); err != nil {
+func (handler *AdapterHttpHandler) release(connection *grpc.ClientConn, logger *Logger) {
+	if releaser, ok := handler.ServiceResolver.(ConnectionReleaser); ok {
+		releaser.Release(connection)
+		return
+	}
+	if err := connection.Close(); err != nil {
+		logger.Debug("error when closing gRPC connection", "error", err)
+	}
+}
+
+// Records the time elapsed since the beginning of the invocation at the end of the given phase
+func (handler *AdapterHttpHandler) endPhase(request *http.Request, phase string, started time.Time) {
+	elapsed := time.Since(started)
+	handler.metrics.observePhase(request, phase, elapsed)
+	recordPhase(request, phase, elapsed)
+}
+
+// Problems cannot be reported to clients that went away
+func reportProblem(logger *Logger, responseWriter http.ResponseWriter, request *http.Request, problem *Problem) {
+	if err := writeProblem(responseWriter, request, problem); err != nil {
+		logger.Debug("error when writing problem", "problem", problem.Type, "error", err)
+	}
+}
+
+// Server-sent events are rendered by the adapter, the function is asked for the event data in the other accepted types
+func newFrameWriter(responseWriter http.ResponseWriter, request *http.Request) (frameWriter, string) {
+	accept := request.Header.Get("Accept")
+	if accepts(accept, eventStreamMediaType) {
+		return &eventStreamWriter{responseWriter: responseWriter}, withoutMediaType(accept, eventStreamMediaType, "text/plain")
+	}
+	if accepts(accept, ndjsonMediaType) {
+		return &ndjsonFrameWriter{responseWriter: responseWriter}, "application/json"
+	}
+	return &chunkedFrameWriter{responseWriter: responseWriter}, accept
+}
+
+func (handler *AdapterHttpHandler) heartbeatInterval() time.Duration {
+	if handler.heartbeat <= 0 {
+		return defaultHeartbeatInterval
+	}
+	return handler.heartbeat
+}
+
+// Newline-delimited JSON bodies are always sent line by line
+func (handler *AdapterHttpHandler) splitter(request *http.Request) BodySplitter {
+	if isNdjson(request) {
+		return &LineSplitter{MaxFrameSize: handler.maxFrameSize}
+	}
+	if handler.bodySplitter == nil {
+		return WholeBodySplitter{}
+	}
+	return handler.bodySplitter
+}
+
+// Sends the Start signal followed by one Next signal per payload extracted from the request body.
+// Only body read errors are returned: send errors terminate the stream and are reported by Recv, they are only logged.
+func sendRequest(client streaming.Riff_InvokeClient, request *http.Request, accept string, splitter BodySplitter, logger *Logger) error {
+	defer func() {
+		if err := request.Body.Close(); err != nil {
+			logger.Debug("error when closing request body", "error", err)
+		}
+	}()
+	if err := client.Send(NewStartSignal(accept)); err != nil {
+		logger.Debug("error when sending Start signal", "error", err)
+		return nil
+	}
+	headers := nextHeaders(request)
+	if isNdjson(request) {
+		headers["Content-Type"] = "application/json"
+	}
+	var sendErr error
+	err := splitter.Split(request.Body, func(payload []byte) error {
+		sendErr = client.Send(NewNextSignal(headers, payload))
+		return sendErr
+	})
+	if sendErr != nil {
+		logger.Debug("error when sending Next signal", "error", sendErr)
+		return nil
+	}
+	if err != nil {
 		return err
 	}
+	// TODO: adapt FrenchizerServer to exhibit the bug fixed by this line
+	if err := client.CloseSend(); err != nil {
+		logger.Debug("error when closing gRPC stream", "error", err)
+	}
 	return nil
 }
 
-func writeResponse(responseWriter http.ResponseWriter, next *streaming.Next) error {
-	responseWriter.WriteHeader(200)
-	for key, value := range next.Headers {
-		if key == "Content-Length" { // TODO: test this
+// Request body failing reads past the limit with a requestBodyTooLarge problem
+type limitedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+}
+
+func (body *limitedBody) Read(buffer []byte) (int, error) {
+	if body.remaining < 0 {
+		return 0, requestBodyTooLarge(body.limit)
+	}
+	// reads one more byte than allowed to tell bodies of the exact limit size from larger ones
+	if int64(len(buffer)) > body.remaining+1 {
+		buffer = buffer[:body.remaining+1]
+	}
+	read, err := body.ReadCloser.Read(buffer)
+	if int64(read) > body.remaining {
+		read = int(body.remaining)
+		body.remaining = -1
+		return read, requestBodyTooLarge(body.limit)
+	}
+	body.remaining -= int64(read)
+	return read, err
+}
+
+func isNdjson(request *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	return err == nil && mediaType == ndjsonMediaType
+}
+
+// Repeated header values are joined with HeaderValueSeparator
+func copyRequestHeaders(headers http.Header, excludedHeader string) map[string]string {
+	result := make(map[string]string)
+	for key, values := range headers {
+		if key == excludedHeader {
 			continue
 		}
-		responseWriter.Header().Add(key, value)
+		result[key] = strings.Join(values, HeaderValueSeparator)
 	}
-	_, err := responseWriter.Write(next.Payload)
-	return err
+	return result
 }
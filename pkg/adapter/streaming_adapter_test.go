@@ -1,14 +1,23 @@
 package adapter_test
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"github.com/golang/protobuf/ptypes/wrappers"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"riff-streaming-adapter/pkg/adapter"
 	"riff-streaming-adapter/streaming"
 	"strings"
@@ -67,6 +76,760 @@ var _ = Describe("Streaming adapter", func() {
 		})
 	})
 
+	Describe("when splitting request bodies", func() {
+		var (
+			grpcConnection   *grpc.ClientConn
+			streamingAdapter *adapter.StreamingAdapter
+			adapterAddress   string
+			httpClient       *http.Client
+		)
+
+		start := func(splitter adapter.BodySplitter) {
+			var grpcAddress string
+			grpcConnection, grpcAddress = openGrpcConnection(NewJoinerServer())
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+				Timeout:         timeout,
+				BodySplitter:    splitter,
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+			httpClient = &http.Client{}
+		}
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+		})
+
+		It("sends a single frame by default", func() {
+			start(nil)
+
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{}, "a\nb\nc"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(asString(response.Body)).To(Equal("a\nb\nc"))
+		})
+
+		It("sends a frame per chunk", func() {
+			start(&adapter.ChunkSplitter{Size: 2})
+
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{}, "abcde"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(asString(response.Body)).To(Equal("ab|cd|e"))
+		})
+
+		It("sends a frame per delimited payload", func() {
+			start(&adapter.DelimiterSplitter{Delimiter: []byte("\n")})
+
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{}, "a\nb\nc"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(asString(response.Body)).To(Equal("a|b|c"))
+		})
+	})
+
+	Describe("when functions emit several frames", func() {
+		var (
+			grpcConnection   *grpc.ClientConn
+			streamingAdapter *adapter.StreamingAdapter
+			adapterAddress   string
+			httpClient       *http.Client
+		)
+
+		BeforeEach(func() {
+			var grpcAddress string
+			grpcConnection, grpcAddress = openGrpcConnection(NewSplitterServer())
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+				Timeout:         timeout,
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+			httpClient = &http.Client{}
+		})
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+		})
+
+		It("streams all of them back", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{}, "a,b,c"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(response.TransferEncoding).To(Equal([]string{"chunked"}))
+			Expect(asString(response.Body)).To(Equal("a\nb\nc\n"))
+		})
+
+		It("truncates the response when the function fails mid-stream", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{}, "a,boom"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			_, err = ioutil.ReadAll(response.Body)
+			Expect(err).To(MatchError(io.ErrUnexpectedEOF))
+		})
+	})
+
+	Describe("when streaming server-sent events", func() {
+		var (
+			grpcConnection   *grpc.ClientConn
+			streamingAdapter *adapter.StreamingAdapter
+			adapterAddress   string
+			httpClient       *http.Client
+		)
+
+		start := func(server *tickerServer, heartbeatInterval time.Duration, streamTimeout time.Duration) {
+			var grpcAddress string
+			grpcConnection, grpcAddress = openGrpcConnection(server)
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver:   &HardcodedResolver{Url: grpcAddress},
+				Timeout:           timeout,
+				StreamTimeout:     streamTimeout,
+				HeartbeatInterval: heartbeatInterval,
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+			httpClient = &http.Client{}
+		}
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+		})
+
+		It("turns every frame into an event, beyond the adapter timeout", func() {
+			start(NewTickerServer(timeout/2), time.Minute, 0)
+
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{"Accept": "text/event-stream"}, ""))
+
+			Expect(err).NotTo(HaveOccurred())
+			defer assertClose(response.Body)
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(response.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+			reader := bufio.NewReader(response.Body)
+			Expect(readEvent(reader)).To(Equal("event: tick\nid: 1\ndata: tick\ndata: 1\n"))
+			Expect(readEvent(reader)).To(Equal("event: tick\nid: 2\ndata: tick\ndata: 2\n"))
+			Expect(readEvent(reader)).To(Equal("event: tick\nid: 3\ndata: tick\ndata: 3\n"))
+		})
+
+		It("sends heartbeats while the function is silent", func() {
+			start(NewTickerServer(time.Minute), 20*time.Millisecond, 0)
+
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{"Accept": "text/event-stream"}, ""))
+
+			Expect(err).NotTo(HaveOccurred())
+			defer assertClose(response.Body)
+			Expect(readEvent(bufio.NewReader(response.Body))).To(Equal(": heartbeat\n"))
+		})
+
+		It("bounds streams by the stream timeout, which requested timeouts are capped to", func() {
+			server := NewTickerServer(time.Minute)
+			start(server, 20*time.Millisecond, time.Second)
+
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{
+				"Accept":              "text/event-stream",
+				adapter.TimeoutHeader: "1H",
+			}, ""))
+
+			Expect(err).NotTo(HaveOccurred())
+			defer assertClose(response.Body)
+			Expect(readEvent(bufio.NewReader(response.Body))).To(Equal(": heartbeat\n"))
+			Expect(server.Remaining()).To(BeNumerically("~", time.Second, 100*time.Millisecond))
+		})
+
+		It("tears the gRPC stream down when the client disconnects", func() {
+			server := NewTickerServer(10 * time.Millisecond)
+			start(server, time.Minute, 0)
+
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{"Accept": "text/event-stream"}, ""))
+			Expect(err).NotTo(HaveOccurred())
+			_, _ = readEvent(bufio.NewReader(response.Body))
+			assertClose(response.Body)
+
+			Eventually(server.Cancelled).Should(BeClosed())
+		})
+	})
+
+	Describe("when clients go away", func() {
+		var (
+			server           *tickerServer
+			grpcConnection   *grpc.ClientConn
+			streamingAdapter *adapter.StreamingAdapter
+			adapterAddress   string
+		)
+
+		start := func(period time.Duration) {
+			var grpcAddress string
+			server = NewTickerServer(period)
+			grpcConnection, grpcAddress = openGrpcConnection(server)
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+				Timeout:         time.Minute,
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+		}
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+		})
+
+		It("cancels invocations of silent functions", func() {
+			start(time.Minute)
+			httpClient := &http.Client{Timeout: 50 * time.Millisecond}
+
+			_, err := httpClient.Do(post(adapterAddress, map[string]string{}, ""))
+
+			Expect(err).To(HaveOccurred())
+			Eventually(server.Cancelled).Should(BeClosed())
+		})
+
+		It("cancels invocations of streaming functions", func() {
+			start(10 * time.Millisecond)
+
+			response, err := (&http.Client{}).Do(post(adapterAddress, map[string]string{}, ""))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = bufio.NewReader(response.Body).ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			assertClose(response.Body)
+
+			Eventually(server.Cancelled).Should(BeClosed())
+		})
+	})
+
+	Describe("when clients set their own timeout", func() {
+		var (
+			server           *tickerServer
+			grpcConnection   *grpc.ClientConn
+			streamingAdapter *adapter.StreamingAdapter
+			adapterAddress   string
+			httpClient       *http.Client
+		)
+
+		BeforeEach(func() {
+			var grpcAddress string
+			server = NewTickerServer(time.Minute)
+			grpcConnection, grpcAddress = openGrpcConnection(server)
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+				Timeout:         time.Second,
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+			httpClient = &http.Client{}
+		})
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+		})
+
+		It("propagates shorter timeouts to the function", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{adapter.TimeoutHeader: "50m"}, ""))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(504))
+			Expect(server.Remaining()).To(BeNumerically("~", 50*time.Millisecond, 40*time.Millisecond))
+		})
+
+		It("releases the connections of stalled uploads once invocations time out", func() {
+			body, bodyWriter := io.Pipe()
+			defer assertClose(bodyWriter)
+			request, err := http.NewRequest("POST", adapterAddress, body)
+			Expect(err).NotTo(HaveOccurred())
+			request.Header.Set(adapter.TimeoutHeader, "50m")
+			responses := make(chan *http.Response, 1)
+			go func() {
+				defer GinkgoRecover()
+				response, err := httpClient.Do(request)
+				Expect(err).NotTo(HaveOccurred())
+				responses <- response
+			}()
+			_, err = bodyWriter.Write([]byte("hello"))
+			Expect(err).NotTo(HaveOccurred())
+
+			var response *http.Response
+			Eventually(responses).Should(Receive(&response))
+			Expect(response.StatusCode).To(Equal(504))
+			assertClose(response.Body)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			Expect(streamingAdapter.Shutdown(ctx)).To(Succeed())
+		})
+
+		It("caps longer timeouts to the adapter timeout", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{adapter.TimeoutHeader: "1H"}, ""))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(504))
+			Expect(server.Remaining()).To(BeNumerically("<=", time.Second))
+		})
+
+		DescribeTable("caps timeouts too long to be represented to the adapter timeout",
+			func(requestedTimeout string) {
+				response, err := httpClient.Do(post(adapterAddress, map[string]string{adapter.TimeoutHeader: requestedTimeout}, ""))
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.StatusCode).To(Equal(504))
+				Expect(server.Remaining()).To(BeNumerically("~", time.Second, 100*time.Millisecond))
+			},
+			Entry("at most 8 digits of hours", "99999999H"),
+			Entry("7 digits of hours", "9999999H"),
+			Entry("just over the longest duration", "3000000H"),
+		)
+
+		It("rejects malformed timeouts", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{
+				"Accept":              "application/problem+json",
+				adapter.TimeoutHeader: "50ms",
+			}, ""))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(400))
+			problem := asProblem(response.Body)
+			Expect(problem.Title).To(Equal("invalid timeout"))
+			Expect(problem.Detail).To(Equal(`"50ms" is invalid: expected unit to be one of H, M, S, m, u, n`))
+		})
+
+		It("rejects zero timeouts", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{
+				"Accept":              "application/problem+json",
+				adapter.TimeoutHeader: "0S",
+			}, ""))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(400))
+			problem := asProblem(response.Body)
+			Expect(problem.Title).To(Equal("invalid timeout"))
+			Expect(problem.Detail).To(Equal(`"0S" is invalid: expected a positive amount`))
+		})
+	})
+
+	Describe("when limiting request bodies", func() {
+		var (
+			grpcConnection   *grpc.ClientConn
+			streamingAdapter *adapter.StreamingAdapter
+			adapterAddress   string
+			httpClient       *http.Client
+		)
+
+		BeforeEach(func() {
+			var grpcAddress string
+			grpcConnection, grpcAddress = openGrpcConnection(NewEchoServer())
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver:    &HardcodedResolver{Url: grpcAddress},
+				Timeout:            time.Second,
+				MaxRequestBodySize: 5,
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+			httpClient = &http.Client{}
+		})
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+		})
+
+		It("accepts bodies up to the limit", func() {
+			response, err := httpClient.Do(post(adapterAddress, nil, "hello"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(asString(response.Body)).To(Equal("hello"))
+		})
+
+		It("rejects bodies announced larger than the limit", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{"Accept": "application/json"}, "hello world"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(413))
+			problem := asProblem(response.Body)
+			Expect(problem.Title).To(Equal("request body too large"))
+			Expect(problem.Detail).To(Equal("request bodies are limited to 5 bytes"))
+		})
+
+		It("rejects streamed bodies growing larger than the limit", func() {
+			request, err := http.NewRequest("POST", adapterAddress, ioutil.NopCloser(strings.NewReader("hello world")))
+			Expect(err).NotTo(HaveOccurred())
+			request.Header.Set("Accept", "application/json")
+
+			response, err := httpClient.Do(request)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(413))
+			Expect(asProblem(response.Body).Type).To(Equal("urn:riff:streaming-adapter:problem:request-body-too-large"))
+		})
+	})
+
+	Describe("when serving TLS", func() {
+		var (
+			grpcConnection   *grpc.ClientConn
+			streamingAdapter *adapter.StreamingAdapter
+			tlsServer        *httptest.Server
+			adapterAddress   string
+		)
+
+		BeforeEach(func() {
+			var grpcAddress string
+			grpcConnection, grpcAddress = openGrpcConnection(NewEchoServer())
+			// borrows the certificate of the test server, valid for 127.0.0.1, along with a client trusting it
+			tlsServer = httptest.NewTLSServer(http.NotFoundHandler())
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+				Timeout:         time.Second,
+				TlsConfig:       &tls.Config{Certificates: tlsServer.TLS.Certificates},
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("https://127.0.0.1:%d", httpPort)
+		})
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+			tlsServer.Close()
+		})
+
+		It("serves HTTPS", func() {
+			response, err := tlsServer.Client().Do(post(adapterAddress, nil, "hello"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(response.TLS).NotTo(BeNil())
+			Expect(asString(response.Body)).To(Equal("hello"))
+		})
+
+		It("refuses plain HTTP", func() {
+			response, err := http.DefaultClient.Do(post(strings.Replace(adapterAddress, "https", "http", 1), nil, "hello"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(400))
+			assertClose(response.Body)
+		})
+	})
+
+	Describe("when shutting down", func() {
+		var (
+			grpcConnection   *grpc.ClientConn
+			streamingAdapter *adapter.StreamingAdapter
+			adapterAddress   string
+			httpClient       *http.Client
+		)
+
+		start := func(server streaming.RiffServer) {
+			var grpcAddress string
+			grpcConnection, grpcAddress = openGrpcConnection(server)
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+				Timeout:         time.Minute,
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+			httpClient = &http.Client{}
+		}
+
+		shutdown := func(gracePeriod time.Duration) <-chan error {
+			result := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+				defer cancel()
+				result <- streamingAdapter.Shutdown(ctx)
+			}()
+			return result
+		}
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+		})
+
+		It("lets in-flight invocations complete while refusing new ones", func() {
+			start(NewSplitterServer())
+			resolved := make(chan struct{}, 1)
+			streamingAdapter.ServiceResolver.(*HardcodedResolver).Resolved = resolved
+			body, bodyWriter := io.Pipe()
+			request, err := http.NewRequest("POST", adapterAddress, body)
+			Expect(err).NotTo(HaveOccurred())
+			responses := make(chan *http.Response, 1)
+			go func() {
+				defer GinkgoRecover()
+				response, err := httpClient.Do(request)
+				Expect(err).NotTo(HaveOccurred())
+				responses <- response
+			}()
+			_, err = bodyWriter.Write([]byte("hello,"))
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(resolved).Should(Receive())
+
+			result := shutdown(time.Minute)
+
+			Eventually(streamingAdapter.Draining).Should(BeTrue())
+			Eventually(func() error {
+				connection, err := net.Dial("tcp", strings.TrimPrefix(adapterAddress, "http://"))
+				if err == nil {
+					_ = connection.Close()
+				}
+				return err
+			}).Should(HaveOccurred())
+			Consistently(result).ShouldNot(Receive())
+			_, err = bodyWriter.Write([]byte("world"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bodyWriter.Close()).To(Succeed())
+			var response *http.Response
+			Eventually(responses).Should(Receive(&response))
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(asString(response.Body)).To(Equal("hello\nworld\n"))
+			Eventually(result).Should(Receive(BeNil()))
+		})
+
+		It("cancels the invocations still in flight at the end of the grace period", func() {
+			server := NewTickerServer(10 * time.Millisecond)
+			start(server)
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{"Accept": "text/event-stream"}, ""))
+			Expect(err).NotTo(HaveOccurred())
+			defer assertClose(response.Body)
+			reader := bufio.NewReader(response.Body)
+			_, err = readEvent(reader)
+			Expect(err).NotTo(HaveOccurred())
+
+			result := shutdown(100 * time.Millisecond)
+
+			Eventually(result, time.Second).Should(Receive(Equal(context.DeadlineExceeded)))
+			Eventually(server.Cancelled).Should(BeClosed())
+			_, err = ioutil.ReadAll(reader)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("when streaming newline-delimited JSON", func() {
+		var (
+			server           *echoServer
+			grpcConnection   *grpc.ClientConn
+			streamingAdapter *adapter.StreamingAdapter
+			adapterAddress   string
+			httpClient       *http.Client
+		)
+
+		BeforeEach(func() {
+			var grpcAddress string
+			server = NewEchoServer()
+			grpcConnection, grpcAddress = openGrpcConnection(server)
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+				Timeout:         timeout,
+				MaxFrameSize:    16,
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+			httpClient = &http.Client{}
+		})
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+		})
+
+		It("sends a JSON frame per line and renders a line per frame", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{
+				"Content-Type": "application/x-ndjson",
+				"Accept":       "application/x-ndjson",
+			}, "{\"a\":1}\n\n{\"b\":2}"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(response.Header.Get("Content-Type")).To(Equal("application/x-ndjson"))
+			Expect(asString(response.Body)).To(Equal("{\"a\":1}\n{\"b\":2}\n"))
+			Expect(server.Accept()).To(Equal("application/json"))
+			Expect(server.Headers()).To(HaveLen(2))
+			for _, headers := range server.Headers() {
+				Expect(headers).To(HaveKeyWithValue("Content-Type", "application/json"))
+			}
+		})
+
+		It("renders every JSON payload on a single line", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{
+				"Content-Type": "application/x-ndjson",
+				"Accept":       "application/x-ndjson",
+			}, "{ \"a\": [1, 2] }"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(asString(response.Body)).To(Equal("{\"a\":[1,2]}\n"))
+		})
+
+		It("fails when the function emits payloads that are not JSON", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{
+				"Content-Type": "application/x-ndjson",
+				"Accept":       "application/x-ndjson",
+			}, "hello"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(502))
+			Expect(asString(response.Body)).To(HavePrefix("misbehaving gRPC server: invalid JSON payload"))
+		})
+
+		It("fails on lines larger than the maximum frame size", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{
+				"Content-Type": "application/x-ndjson",
+				"Accept":       "application/x-ndjson",
+			}, "{\"a\":\"0123456789abcdef\"}"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(400))
+			Expect(asString(response.Body)).To(HavePrefix("unreadable request body"))
+		})
+	})
+
+	Describe("when describing requests to functions", func() {
+		var (
+			server           *echoServer
+			grpcConnection   *grpc.ClientConn
+			streamingAdapter *adapter.StreamingAdapter
+			adapterAddress   string
+			httpClient       *http.Client
+		)
+
+		BeforeEach(func() {
+			var grpcAddress string
+			server = NewEchoServer()
+			grpcConnection, grpcAddress = openGrpcConnection(server)
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+				Timeout:         timeout,
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+			httpClient = &http.Client{}
+		})
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+		})
+
+		It("forwards the method, path, query string and remote address", func() {
+			response, err := httpClient.Do(post(adapterAddress+"/orders/some%2Fid?verbose=true&page=2", map[string]string{
+				"X-Riff-Method": "spoofed",
+				"X-Custom":      "value",
+			}, "payload"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(server.Headers()).To(HaveLen(1))
+			headers := server.Headers()[0]
+			Expect(headers).To(HaveKeyWithValue(adapter.MethodHeader, "POST"))
+			Expect(headers).To(HaveKeyWithValue(adapter.PathHeader, "/orders/some%2Fid"))
+			Expect(headers).To(HaveKeyWithValue(adapter.QueryHeader, "verbose=true&page=2"))
+			Expect(headers).To(HaveKeyWithValue(adapter.RemoteAddrHeader, HavePrefix("127.0.0.1:")))
+			Expect(headers).To(HaveKeyWithValue("X-Custom", "value"))
+		})
+
+		It("forwards every value of repeated headers", func() {
+			request := post(adapterAddress, map[string]string{}, "payload")
+			request.Header.Add("X-Forwarded-For", "10.0.0.1")
+			request.Header.Add("X-Forwarded-For", "10.0.0.2")
+
+			response, err := httpClient.Do(request)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(server.Headers()).To(HaveLen(1))
+			Expect(server.Headers()[0]).To(HaveKeyWithValue("X-Forwarded-For", "10.0.0.1\n10.0.0.2"))
+		})
+	})
+
+	Describe("when functions control the response head", func() {
+		var (
+			grpcConnection   *grpc.ClientConn
+			streamingAdapter *adapter.StreamingAdapter
+			adapterAddress   string
+			httpClient       *http.Client
+		)
+
+		BeforeEach(func() {
+			var grpcAddress string
+			grpcConnection, grpcAddress = openGrpcConnection(NewHeadersServer())
+			httpPort := findFreePort()
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+				Timeout:         timeout,
+			}
+			Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+			adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+			httpClient = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}}
+		})
+
+		AfterEach(func() {
+			assertClose(streamingAdapter)
+			assertClose(grpcConnection)
+		})
+
+		It("honors the status and headers of the first frame", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{},
+				`{"X-Riff-Status": "201", "content-type": "application/json", "Location": "/orders/42"}`))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(201))
+			Expect(response.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(response.Header.Get("Location")).To(Equal("/orders/42"))
+			Expect(response.Header).NotTo(HaveKey(adapter.StatusHeader))
+			Expect(asString(response.Body)).To(Equal("body"))
+		})
+
+		It("splits repeated header values", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{}, `{"Set-Cookie": "a=1\nb=2"}`))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(response.Header["Set-Cookie"]).To(Equal([]string{"a=1", "b=2"}))
+		})
+
+		It("supports redirects", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{},
+				`{"X-Riff-Status": "307", "Location": "http://example.com"}`))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(307))
+			Expect(response.Header.Get("Location")).To(Equal("http://example.com"))
+		})
+
+		It("ignores the content length set by the function", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{}, `{"Content-Length": "1000"}`))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+			Expect(asString(response.Body)).To(Equal("body"))
+		})
+
+		It("returns 5xx errors for invalid statuses", func() {
+			response, err := httpClient.Do(post(adapterAddress, map[string]string{}, `{"X-Riff-Status": "teapot", "X-Custom": "value"}`))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(502))
+			Expect(response.Header).NotTo(HaveKey("X-Custom"))
+			Expect(asString(response.Body)).To(Equal(`misbehaving gRPC server: invalid status "teapot"`))
+		})
+	})
+
 	Describe("when given wrong arguments", func() {
 		var streamingAdapter *adapter.StreamingAdapter
 
@@ -101,7 +864,57 @@ var _ = Describe("Streaming adapter", func() {
 
 			Expect(err).NotTo(HaveOccurred())
 			Expect(response.StatusCode).To(Equal(502))
-			Expect(asString(response.Body)).To(Equal("unreachable gRPC server"))
+			Expect(response.Header.Get("Content-Type")).To(Equal("application/problem+json"))
+			problem := asProblem(response.Body)
+			Expect(problem.Type).To(Equal("urn:riff:streaming-adapter:problem:unreachable-function"))
+			Expect(problem.Title).To(Equal("unreachable gRPC server"))
+			Expect(problem.Status).To(Equal(502))
+		})
+
+		It("renders problems as text to clients not accepting JSON", func() {
+			port := findFreePort()
+			adapterAddress := fmt.Sprintf("http://localhost:%d", port)
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: &HardcodedKoResolver{},
+				Timeout:         timeout,
+			}
+			Expect(streamingAdapter.Start(port)).To(Succeed())
+			defer assertClose(streamingAdapter)
+
+			response, err := (&http.Client{}).Do(post(adapterAddress, map[string]string{"Accept": "text/plain"}, "1"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(502))
+			Expect(response.Header.Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+			Expect(asString(response.Body)).To(HavePrefix("unreachable gRPC server: rpc error: code = Unavailable"))
+		})
+
+		It("returns 4xx problems for resolution errors", func() {
+			port := findFreePort()
+			adapterAddress := fmt.Sprintf("http://localhost:%d", port)
+			streamingAdapter = &adapter.StreamingAdapter{
+				ServiceResolver: &adapter.KnativeServiceResolver{},
+				Timeout:         timeout,
+			}
+			Expect(streamingAdapter.Start(port)).To(Succeed())
+			defer assertClose(streamingAdapter)
+
+			response, err := (&http.Client{}).Do(post(adapterAddress, map[string]string{
+				"Accept":       "application/problem+json",
+				"X-Riff":       "unstructured",
+				"X-Request-Id": "some-id",
+			}, "1"))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(400))
+			Expect(asProblem(response.Body)).To(Equal(&adapter.Problem{
+				Type:      "urn:riff:streaming-adapter:problem:invalid-function-name",
+				Title:     "invalid function name",
+				Status:    400,
+				Detail:    `"unstructured" is invalid: expected name to follow SERVICE_NAME/NAMESPACE structure`,
+				Function:  "unstructured",
+				RequestId: "some-id",
+			}))
 		})
 
 		It("returns 5xx errors when the gRPC server fails to be invoked", func() {
@@ -124,9 +937,51 @@ var _ = Describe("Streaming adapter", func() {
 
 			Expect(err).NotTo(HaveOccurred())
 			Expect(response.StatusCode).To(Equal(502))
-			Expect(asString(response.Body)).To(Equal("misbehaving gRPC server"))
+			problem := asProblem(response.Body)
+			Expect(problem.Title).To(Equal("gRPC server failed with Unknown"))
+			Expect(problem.Detail).To(Equal("nope"))
 		})
 
+		DescribeTable("maps gRPC statuses to HTTP responses",
+			func(code codes.Code, expectedStatus int) {
+				port := findFreePort()
+				adapterAddress := fmt.Sprintf("http://localhost:%d", port)
+				grpcConnection, grpcAddress := openGrpcConnection(ErroringFrenchizerServer(func(int) error {
+					grpcStatus, err := status.New(code, "nope").WithDetails(&wrappers.StringValue{Value: "some detail"})
+					Expect(err).NotTo(HaveOccurred())
+					return grpcStatus.Err()
+				}))
+				defer assertClose(grpcConnection)
+				streamingAdapter = &adapter.StreamingAdapter{
+					ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+					Timeout:         timeout,
+				}
+				Expect(streamingAdapter.Start(port)).To(Succeed())
+				defer assertClose(streamingAdapter)
+
+				response, err := (&http.Client{}).Do(post(adapterAddress, map[string]string{"Accept": "application/json"}, "1"))
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.StatusCode).To(Equal(expectedStatus))
+				problem := asProblem(response.Body)
+				Expect(problem.Type).To(Equal("urn:riff:streaming-adapter:problem:function-failure"))
+				Expect(problem.Title).To(Equal(fmt.Sprintf("gRPC server failed with %s", code)))
+				Expect(problem.Status).To(Equal(expectedStatus))
+				Expect(problem.Detail).To(Equal("nope\nvalue:\"some detail\" "))
+			},
+			Entry("invalid argument", codes.InvalidArgument, 400),
+			Entry("unauthenticated", codes.Unauthenticated, 401),
+			Entry("permission denied", codes.PermissionDenied, 403),
+			Entry("not found", codes.NotFound, 404),
+			Entry("already exists", codes.AlreadyExists, 409),
+			Entry("resource exhausted", codes.ResourceExhausted, 429),
+			Entry("internal", codes.Internal, 500),
+			Entry("unimplemented", codes.Unimplemented, 501),
+			Entry("unknown", codes.Unknown, 502),
+			Entry("unavailable", codes.Unavailable, 503),
+			Entry("deadline exceeded", codes.DeadlineExceeded, 504),
+		)
+
 		It("returns 5xx errors only for failing invocations", func() {
 			port := findFreePort()
 			adapterAddress := fmt.Sprintf("http://localhost:%d", port)
@@ -192,11 +1047,29 @@ func openGrpcConnection(server streaming.RiffServer) (*grpc.ClientConn, string)
 	return connection, fmt.Sprintf("localhost:%d", portOf(listener))
 }
 
+// Starts an adapter invoking functions through a pool of connections to the targets of resolver,
+// returning the pool, the address of the adapter and a function stopping both
+func startPooledAdapter(resolver adapter.TargetResolver) (*adapter.ConnectionPool, string, func()) {
+	pool := adapter.NewConnectionPool(resolver, time.Minute)
+	httpPort := findFreePort()
+	streamingAdapter := &adapter.StreamingAdapter{ServiceResolver: pool, Timeout: time.Minute}
+	Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+	return pool, fmt.Sprintf("http://localhost:%d", httpPort), func() {
+		assertClose(streamingAdapter)
+		assertClose(pool)
+	}
+}
+
 type HardcodedResolver struct {
 	Url string
+	// notified of every resolved request, if set
+	Resolved chan<- struct{}
 }
 
 func (hr *HardcodedResolver) Resolve(*http.Request) (*grpc.ClientConn, error) {
+	if hr.Resolved != nil {
+		hr.Resolved <- struct{}{}
+	}
 	return grpc.Dial(hr.Url, grpc.WithInsecure())
 }
 
@@ -215,6 +1088,27 @@ func post(url string, headers map[string]string, body string) *http.Request {
 	return request
 }
 
+// reads lines until the blank line terminating a server-sent event
+func readEvent(reader *bufio.Reader) (string, error) {
+	var result strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line == "\n" {
+			return result.String(), nil
+		}
+		result.WriteString(line)
+	}
+}
+
+func asProblem(body io.ReadCloser) *adapter.Problem {
+	problem := &adapter.Problem{}
+	Expect(json.NewDecoder(body).Decode(problem)).To(Succeed())
+	return problem
+}
+
 func asString(body io.ReadCloser) string {
 	result, err := ioutil.ReadAll(body)
 	Expect(err).NotTo(HaveOccurred())
@@ -0,0 +1,62 @@
+package adapter_test // visible for tests only
+
+import (
+	"context"
+	"fmt"
+	"riff-streaming-adapter/pkg/adapter"
+	"riff-streaming-adapter/streaming"
+	"sync"
+	"time"
+)
+
+// gRPC server that emits a NEXT signal every period, as long as the client keeps the stream open.
+// Every signal is tagged with server-sent event headers.
+// It closes Cancelled once the client tears the stream down, and records the remaining time before the stream deadline.
+type tickerServer struct {
+	period    time.Duration
+	Cancelled chan struct{}
+	mutex     sync.Mutex
+	remaining time.Duration
+}
+
+func NewTickerServer(period time.Duration) *tickerServer {
+	return &tickerServer{period: period, Cancelled: make(chan struct{})}
+}
+
+func (ticker *tickerServer) Invoke(server streaming.Riff_InvokeServer) error {
+	if deadline, found := server.Context().Deadline(); found {
+		ticker.mutex.Lock()
+		ticker.remaining = time.Until(deadline)
+		ticker.mutex.Unlock()
+	}
+	if _, err := server.Recv(); err != nil {
+		return err
+	}
+	defer func() {
+		if server.Context().Err() == context.Canceled {
+			close(ticker.Cancelled)
+		}
+	}()
+	for i := 1; ; i++ {
+		select {
+		case <-server.Context().Done():
+			return server.Context().Err()
+		case <-time.After(ticker.period):
+			signal := nextSignal(fmt.Sprintf("tick\n%d", i))
+			signal.GetNext().Headers = map[string]string{
+				adapter.SseEventHeader: "tick",
+				adapter.SseIdHeader:    fmt.Sprintf("%d", i),
+			}
+			if err := server.Send(signal); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// zero if the stream has no deadline
+func (ticker *tickerServer) Remaining() time.Duration {
+	ticker.mutex.Lock()
+	defer ticker.mutex.Unlock()
+	return ticker.remaining
+}
@@ -0,0 +1,44 @@
+package adapter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Request header through which clients ask for a shorter invocation timeout than the adapter's
+const TimeoutHeader = "X-Riff-Timeout"
+
+var timeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// Parses timeouts following the "grpc-timeout" format of gRPC over HTTP/2: a positive integer of at most 8 digits
+// followed by a unit, among H (hours), M (minutes), S (seconds), m (milliseconds), u (microseconds) and n (nanoseconds).
+// Timeouts too long to be represented are capped to the longest duration, itself capped later by the adapter timeout.
+func parseTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("%q is invalid: expected 1 to 8 digits followed by a unit", value)
+	}
+	unit, found := timeoutUnits[value[len(value)-1]]
+	if !found {
+		return 0, fmt.Errorf("%q is invalid: expected unit to be one of H, M, S, m, u, n", value)
+	}
+	amount, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is invalid: expected 1 to 8 digits followed by a unit", value)
+	}
+	if amount == 0 {
+		return 0, fmt.Errorf("%q is invalid: expected a positive amount", value)
+	}
+	if amount > uint64(math.MaxInt64/unit) {
+		return time.Duration(math.MaxInt64), nil
+	}
+	return time.Duration(amount) * unit, nil
+}
@@ -0,0 +1,278 @@
+package adapter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Trace context headers, as defined by W3C Trace Context and B3 propagation
+const (
+	TraceParentHeader = "Traceparent"
+	TraceStateHeader  = "Tracestate"
+	B3Header          = "B3"
+	b3TraceIdHeader   = "X-B3-Traceid"
+	b3SpanIdHeader    = "X-B3-Spanid"
+	b3ParentHeader    = "X-B3-Parentspanid"
+	b3SampledHeader   = "X-B3-Sampled"
+	b3FlagsHeader     = "X-B3-Flags"
+)
+
+type SpanKind int
+
+// Values as defined by OTLP
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type TraceId [16]byte
+
+func (id TraceId) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type SpanId [8]byte
+
+func (id SpanId) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Receives the spans ended by the invocations, typically to send them to a collector.
+// Export must not block.
+type SpanExporter interface {
+	Export(span *Span)
+}
+
+// Creates a span per invocation, as a child of the trace context of the HTTP request, if any, along with a child span
+// per invocation phase: resolve, dial, send and receive.
+// The invocation span context is propagated to the function, both as gRPC metadata and as Next headers,
+// in the W3C Trace Context and B3 single header formats.
+type Tracer struct {
+	// receives the sampled spans
+	Exporter SpanExporter
+}
+
+// Unit of work of a trace. Unsampled spans are propagated, but not exported.
+type Span struct {
+	Name         string
+	Kind         SpanKind
+	TraceId      TraceId
+	SpanId       SpanId
+	ParentSpanId SpanId
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	// set when the unit of work failed
+	Error      error
+	sampled    bool
+	traceState string
+	tracer     *Tracer
+}
+
+type spanKey struct{}
+
+// Traces the requests served by next
+func (tracer *Tracer) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		span := tracer.startSpan(extractTraceContext(request.Header), "invoke", SpanKindServer)
+		span.Attributes["http.method"] = request.Method
+		span.Attributes["http.target"] = request.URL.RequestURI()
+		span.Attributes["riff.function"] = request.Header.Get("X-Riff")
+		recorder := &recordingResponseWriter{ResponseWriter: responseWriter}
+		// handlers abort by panicking, see abortIfCommitted
+		defer func() {
+			status := recorder.statusCode()
+			span.Attributes["http.status_code"] = status
+			if status >= 500 {
+				span.Error = fmt.Errorf("HTTP status %d", status)
+			}
+			span.end()
+		}()
+		next.ServeHTTP(recorder, request.WithContext(context.WithValue(request.Context(), spanKey{}, span)))
+	})
+}
+
+// The span is a root span if the parent is nil. Root spans are always sampled.
+func (tracer *Tracer) startSpan(parent *Span, name string, kind SpanKind) *Span {
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		SpanId:     newSpanId(),
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+		sampled:    true,
+		tracer:     tracer,
+	}
+	if parent == nil {
+		_, _ = rand.Read(span.TraceId[:])
+		return span
+	}
+	span.TraceId = parent.TraceId
+	span.ParentSpanId = parent.SpanId
+	span.sampled = parent.sampled
+	span.traceState = parent.traceState
+	return span
+}
+
+// Starts a child of the span of the request, if traced. Methods of nil spans do nothing.
+func startChildSpan(ctx context.Context, name string, kind SpanKind) *Span {
+	parent, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok {
+		return nil
+	}
+	return parent.tracer.startSpan(parent, name, kind)
+}
+
+// Ends the span, marking it as failed if err is not nil
+func (span *Span) endWith(err error) {
+	if span == nil {
+		return
+	}
+	span.Error = err
+	span.end()
+}
+
+func (span *Span) end() {
+	span.End = time.Now()
+	if span.sampled && span.tracer.Exporter != nil {
+		span.tracer.Exporter.Export(span)
+	}
+}
+
+func (span *Span) traceParent() string {
+	flags := "00"
+	if span.sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", span.TraceId, span.SpanId, flags)
+}
+
+func (span *Span) b3() string {
+	sampled := "0"
+	if span.sampled {
+		sampled = "1"
+	}
+	return fmt.Sprintf("%s-%s-%s", span.TraceId, span.SpanId, sampled)
+}
+
+// Replaces the trace context of the given Next headers by the one of the span of the request, if traced
+func injectTraceHeaders(ctx context.Context, headers map[string]string) {
+	span, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok {
+		return
+	}
+	for key := range headers {
+		switch http.CanonicalHeaderKey(key) {
+		case TraceParentHeader, TraceStateHeader, B3Header, b3TraceIdHeader, b3SpanIdHeader, b3ParentHeader, b3SampledHeader, b3FlagsHeader:
+			delete(headers, key)
+		}
+	}
+	headers[TraceParentHeader] = span.traceParent()
+	if span.traceState != "" {
+		headers[TraceStateHeader] = span.traceState
+	}
+	headers[B3Header] = span.b3()
+}
+
+// Adds the trace context of the span of the request, if traced, to the outgoing gRPC metadata
+func injectTraceMetadata(ctx context.Context) context.Context {
+	span, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok {
+		return ctx
+	}
+	pairs := []string{"traceparent", span.traceParent(), "b3", span.b3()}
+	if span.traceState != "" {
+		pairs = append(pairs, "tracestate", span.traceState)
+	}
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
+
+// Returns the remote span described by the W3C Trace Context headers or, failing that, by the B3 ones.
+// Returns nil if there are none, or if they are invalid.
+func extractTraceContext(headers http.Header) *Span {
+	if traceParent := headers.Get(TraceParentHeader); traceParent != "" {
+		span := parseTraceParent(traceParent)
+		if span != nil {
+			span.traceState = strings.Join(headers[TraceStateHeader], ",")
+		}
+		return span
+	}
+	if b3 := headers.Get(B3Header); b3 != "" {
+		return parseB3(b3)
+	}
+	if traceId := headers.Get(b3TraceIdHeader); traceId != "" {
+		sampled := headers.Get(b3SampledHeader)
+		if headers.Get(b3FlagsHeader) == "1" {
+			sampled = "d"
+		}
+		return newRemoteSpan(traceId, headers.Get(b3SpanIdHeader), sampled != "0" && sampled != "false")
+	}
+	return nil
+}
+
+// version-traceid-parentid-flags, future versions may append fields
+func parseTraceParent(traceParent string) *Span {
+	fields := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" || (fields[0] == "00" && len(fields) != 4) {
+		return nil
+	}
+	if len(fields[1]) != 32 || len(fields[3]) != 2 {
+		return nil
+	}
+	flags, err := hex.DecodeString(fields[3])
+	if err != nil {
+		return nil
+	}
+	return newRemoteSpan(fields[1], fields[2], flags[0]&1 == 1)
+}
+
+// traceid-spanid[-sampled[-parentspanid]], a lone sampling state carries no context
+func parseB3(b3 string) *Span {
+	fields := strings.Split(strings.TrimSpace(b3), "-")
+	if len(fields) < 2 {
+		return nil
+	}
+	sampled := len(fields) < 3 || fields[2] != "0"
+	return newRemoteSpan(fields[0], fields[1], sampled)
+}
+
+// B3 trace IDs may be 64-bit long
+func newRemoteSpan(traceId string, spanId string, sampled bool) *Span {
+	if len(traceId) == 16 {
+		traceId = strings.Repeat("0", 16) + traceId
+	}
+	span := &Span{sampled: sampled}
+	if !decodeId(span.TraceId[:], traceId) || !decodeId(span.SpanId[:], spanId) {
+		return nil
+	}
+	return span
+}
+
+// IDs are lowercase hexadecimal strings of the exact length, and must not be all zeros
+func decodeId(id []byte, value string) bool {
+	if len(value) != 2*len(id) || strings.ToLower(value) != value {
+		return false
+	}
+	if _, err := hex.Decode(id, []byte(value)); err != nil {
+		return false
+	}
+	for _, b := range id {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func newSpanId() SpanId {
+	var id SpanId
+	_, _ = rand.Read(id[:])
+	return id
+}
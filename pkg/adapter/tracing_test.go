@@ -0,0 +1,235 @@
+package adapter_test
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"net/http"
+	"net/http/httptest"
+	"riff-streaming-adapter/pkg/adapter"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ = Describe("Tracing", func() {
+
+	const (
+		traceId      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanId = "00f067aa0ba902b7"
+	)
+
+	var (
+		server           *echoServer
+		grpcConnection   *grpc.ClientConn
+		streamingAdapter *adapter.StreamingAdapter
+		adapterAddress   string
+		httpClient       *http.Client
+		collector        *otlpCollector
+		exporter         *adapter.OtlpExporter
+	)
+
+	BeforeEach(func() {
+		var grpcAddress string
+		server = NewEchoServer()
+		grpcConnection, grpcAddress = openGrpcConnection(server)
+		collector = &otlpCollector{}
+		collector.server = httptest.NewServer(collector)
+		var err error
+		exporter, err = adapter.NewOtlpExporter(collector.server.URL, "test-adapter")
+		Expect(err).NotTo(HaveOccurred())
+		httpPort := findFreePort()
+		streamingAdapter = &adapter.StreamingAdapter{
+			ServiceResolver: &HardcodedResolver{Url: grpcAddress},
+			Timeout:         time.Minute,
+			Tracer:          &adapter.Tracer{Exporter: exporter},
+		}
+		Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+		adapterAddress = fmt.Sprintf("http://localhost:%d", httpPort)
+		httpClient = &http.Client{}
+	})
+
+	AfterEach(func() {
+		assertClose(streamingAdapter)
+		assertClose(grpcConnection)
+		assertClose(exporter)
+		collector.server.Close()
+	})
+
+	invoke := func(headers map[string]string) {
+		response, err := httpClient.Do(post(adapterAddress, headers, "hello"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(200))
+		Expect(asString(response.Body)).To(Equal("hello"))
+	}
+
+	It("continues W3C traces and propagates them to the function", func() {
+		invoke(map[string]string{
+			"Traceparent": fmt.Sprintf("00-%s-%s-01", traceId, parentSpanId),
+			"Tracestate":  "vendor=value",
+		})
+		assertClose(exporter)
+
+		spans := collector.Spans()
+		Expect(spans).To(HaveLen(5))
+		invocation := spans["invoke"]
+		Expect(invocation.TraceId).To(Equal(traceId))
+		Expect(invocation.ParentSpanId).To(Equal(parentSpanId))
+		Expect(invocation.Kind).To(Equal(2))
+		Expect(invocation.Attributes).To(ContainElement(otlpAttribute{Key: "http.status_code", Value: otlpValue{IntValue: "200"}}))
+		Expect(invocation.Status).To(BeNil())
+		for _, phase := range []string{"resolve", "dial", "send", "receive"} {
+			Expect(spans[phase].TraceId).To(Equal(traceId), phase)
+			Expect(spans[phase].ParentSpanId).To(Equal(invocation.SpanId), phase)
+		}
+		headers := server.Headers()[0]
+		traceParent := fmt.Sprintf("00-%s-%s-01", traceId, invocation.SpanId)
+		Expect(headers["Traceparent"]).To(Equal(traceParent))
+		Expect(headers["Tracestate"]).To(Equal("vendor=value"))
+		Expect(headers["B3"]).To(Equal(fmt.Sprintf("%s-%s-1", traceId, invocation.SpanId)))
+		Expect(server.Metadata().Get("traceparent")).To(Equal([]string{traceParent}))
+		Expect(server.Metadata().Get("tracestate")).To(Equal([]string{"vendor=value"}))
+		Expect(collector.ServiceNames()).To(ConsistOf("test-adapter"))
+	})
+
+	It("continues B3 traces", func() {
+		invoke(map[string]string{
+			"X-B3-TraceId": "a3ce929d0e0e4736",
+			"X-B3-SpanId":  parentSpanId,
+			"X-B3-Sampled": "1",
+		})
+		assertClose(exporter)
+
+		invocation := collector.Spans()["invoke"]
+		Expect(invocation.TraceId).To(Equal("0000000000000000a3ce929d0e0e4736"))
+		Expect(invocation.ParentSpanId).To(Equal(parentSpanId))
+		headers := server.Headers()[0]
+		Expect(headers["Traceparent"]).To(HavePrefix("00-0000000000000000a3ce929d0e0e4736-"))
+		Expect(headers).NotTo(HaveKey("X-B3-Traceid"))
+		Expect(headers).NotTo(HaveKey("X-B3-Spanid"))
+		Expect(headers).NotTo(HaveKey("X-B3-Sampled"))
+	})
+
+	It("starts new traces", func() {
+		invoke(map[string]string{"Traceparent": "00-invalid"})
+		assertClose(exporter)
+
+		invocation := collector.Spans()["invoke"]
+		Expect(invocation.TraceId).NotTo(Equal(strings.Repeat("0", 32)))
+		Expect(invocation.ParentSpanId).To(BeEmpty())
+		Expect(server.Headers()[0]["Traceparent"]).To(Equal(fmt.Sprintf("00-%s-%s-01", invocation.TraceId, invocation.SpanId)))
+	})
+
+	It("propagates unsampled traces without exporting them", func() {
+		invoke(map[string]string{"Traceparent": fmt.Sprintf("00-%s-%s-00", traceId, parentSpanId)})
+		assertClose(exporter)
+
+		Expect(collector.Spans()).To(BeEmpty())
+		traceParent := server.Headers()[0]["Traceparent"]
+		Expect(traceParent).To(HavePrefix("00-" + traceId + "-"))
+		Expect(traceParent).To(HaveSuffix("-00"))
+	})
+
+	It("marks failed invocations", func() {
+		response, err := httpClient.Do(post(adapterAddress, map[string]string{adapter.TimeoutHeader: "1n"}, "hello"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(502))
+		assertClose(response.Body)
+		assertClose(exporter)
+
+		spans := collector.Spans()
+		Expect(spans["invoke"].Status).NotTo(BeNil())
+		Expect(spans["invoke"].Status.Code).To(Equal(2))
+		Expect(spans["invoke"].Status.Message).To(Equal("HTTP status 502"))
+		Expect(spans["dial"].Status).NotTo(BeNil())
+		Expect(spans["dial"].Status.Code).To(Equal(2))
+	})
+})
+
+// OpenTelemetry collector recording the spans it receives with the OTLP/HTTP JSON protocol
+type otlpCollector struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	requests []otlpRequest
+}
+
+type otlpRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type otlpSpan struct {
+	TraceId      string          `json:"traceId"`
+	SpanId       string          `json:"spanId"`
+	ParentSpanId string          `json:"parentSpanId"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Attributes   []otlpAttribute `json:"attributes"`
+	Status       *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+	IntValue    string `json:"intValue"`
+}
+
+func (collector *otlpCollector) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	defer GinkgoRecover()
+	Expect(request.URL.Path).To(Equal("/v1/traces"))
+	Expect(request.Header.Get("Content-Type")).To(Equal("application/json"))
+	var otlpRequest otlpRequest
+	Expect(json.NewDecoder(request.Body).Decode(&otlpRequest)).To(Succeed())
+	collector.mutex.Lock()
+	collector.requests = append(collector.requests, otlpRequest)
+	collector.mutex.Unlock()
+	responseWriter.WriteHeader(200)
+}
+
+// received spans by name
+func (collector *otlpCollector) Spans() map[string]otlpSpan {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	result := make(map[string]otlpSpan)
+	for _, request := range collector.requests {
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				for _, span := range scopeSpans.Spans {
+					result[span.Name] = span
+				}
+			}
+		}
+	}
+	return result
+}
+
+func (collector *otlpCollector) ServiceNames() []string {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	var result []string
+	for _, request := range collector.requests {
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, attribute := range resourceSpans.Resource.Attributes {
+				if attribute.Key == "service.name" {
+					result = append(result, attribute.Value.StringValue)
+				}
+			}
+		}
+	}
+	return result
+}
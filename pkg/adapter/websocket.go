@@ -0,0 +1,124 @@
+package adapter
+
+import (
+	"context"
+	"golang.org/x/net/websocket"
+	"io"
+	"net/http"
+	"net/url"
+	"riff-streaming-adapter/streaming"
+	"strings"
+	"unicode/utf8"
+)
+
+// WebSocket subprotocols cannot carry media types as is, the ones below stand for the media type the function is asked for
+var webSocketSubprotocols = map[string]string{
+	"json":   "application/json",
+	"text":   "text/plain",
+	"binary": "application/octet-stream",
+}
+
+func isWebSocketUpgrade(request *http.Request) bool {
+	return strings.EqualFold(request.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(request.Header.Get("Connection")), "upgrade")
+}
+
+// Browsers send the origin of the page opening a WebSocket connection, along with the cookies of the adapter host.
+// Only the pages served by the adapter host, or by the allowed origins, may open connections, "*" allowing any.
+// Clients other than browsers need not send any origin.
+func allowsOrigin(request *http.Request, allowedOrigins []string) bool {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowedOrigin := range allowedOrigins {
+		if allowedOrigin == "*" || strings.EqualFold(strings.TrimSuffix(allowedOrigin, "/"), origin) {
+			return true
+		}
+	}
+	parsedOrigin, err := url.Parse(origin)
+	return err == nil && parsedOrigin.Host != "" && strings.EqualFold(parsedOrigin.Host, request.Host)
+}
+
+// Bridges the WebSocket connection to the bidirectional Invoke stream: every inbound message is sent as a Next signal,
+// every Next signal emitted by the function is written back as a message (text if valid UTF-8, binary otherwise).
+// The Start signal carries the media type of the first known subprotocol offered by the client, if any, or the Accept header.
+// The connection is closed as soon as the function is done, the invocation is cancelled if the connection breaks.
+func serveWebSocket(client streaming.Riff_InvokeClient, cancel context.CancelFunc, responseWriter http.ResponseWriter, request *http.Request, logger *Logger) {
+	accept := request.Header.Get("Accept")
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, request *http.Request) error {
+			// headers already set by the adapter, e.g. X-Request-Id
+			config.Header = responseWriter.Header()
+			offeredProtocols := config.Protocol
+			config.Protocol = nil
+			for _, protocol := range offeredProtocols {
+				if mediaType, found := webSocketSubprotocols[protocol]; found {
+					config.Protocol = []string{protocol}
+					accept = mediaType
+					break
+				}
+			}
+			return nil
+		},
+		Handler: func(connection *websocket.Conn) {
+			defer func() {
+				if err := connection.Close(); err != nil {
+					logger.Debug("error when closing WebSocket connection", "error", err)
+				}
+			}()
+			if err := client.Send(NewStartSignal(accept)); err != nil {
+				logger.Debug("error when sending Start signal", "error", err)
+				return
+			}
+			go forwardMessages(connection, client, cancel, nextHeaders(request), logger)
+			for {
+				signal, err := client.Recv()
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					logger.Debug("error when receiving Next signal", "error", err)
+					return
+				}
+				if next := signal.GetNext(); next != nil {
+					if err := sendMessage(connection, next.Payload); err != nil {
+						logger.Debug("error when sending WebSocket message", "error", err)
+						return
+					}
+				}
+			}
+		},
+	}
+	server.ServeHTTP(responseWriter, request)
+}
+
+// Sends every inbound message as a Next signal, until the client closes the connection
+func forwardMessages(connection *websocket.Conn, client streaming.Riff_InvokeClient, cancel context.CancelFunc, headers map[string]string, logger *Logger) {
+	for {
+		var payload []byte
+		err := websocket.Message.Receive(connection, &payload)
+		if err == io.EOF {
+			if err := client.CloseSend(); err != nil {
+				logger.Debug("error when closing gRPC stream", "error", err)
+			}
+			return
+		}
+		if err != nil {
+			logger.Debug("error when receiving WebSocket message", "error", err)
+			cancel()
+			return
+		}
+		if err := client.Send(NewNextSignal(headers, payload)); err != nil {
+			logger.Debug("error when sending Next signal", "error", err)
+			return
+		}
+	}
+}
+
+func sendMessage(connection *websocket.Conn, payload []byte) error {
+	if utf8.Valid(payload) {
+		return websocket.Message.Send(connection, string(payload))
+	}
+	return websocket.Message.Send(connection, payload)
+}
@@ -0,0 +1,142 @@
+package adapter_test
+
+import (
+	"context"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+	"io"
+	"net/http"
+	"riff-streaming-adapter/pkg/adapter"
+	"riff-streaming-adapter/streaming"
+	"time"
+)
+
+var _ = Describe("WebSocket bridge", func() {
+
+	var (
+		grpcConnection   *grpc.ClientConn
+		streamingAdapter *adapter.StreamingAdapter
+		adapterAddress   string
+	)
+
+	start := func(server streaming.RiffServer, origins ...string) {
+		var grpcAddress string
+		grpcConnection, grpcAddress = openGrpcConnection(server)
+		httpPort := findFreePort()
+		streamingAdapter = &adapter.StreamingAdapter{
+			ServiceResolver:  &HardcodedResolver{Url: grpcAddress},
+			Timeout:          200 * time.Millisecond,
+			WebSocketOrigins: origins,
+		}
+		Expect(streamingAdapter.Start(httpPort)).To(Succeed())
+		adapterAddress = fmt.Sprintf("localhost:%d", httpPort)
+	}
+
+	dial := func(protocols ...string) *websocket.Conn {
+		config, err := websocket.NewConfig(fmt.Sprintf("ws://%s/", adapterAddress), fmt.Sprintf("http://%s/", adapterAddress))
+		Expect(err).NotTo(HaveOccurred())
+		config.Protocol = protocols
+		connection, err := websocket.DialConfig(config)
+		Expect(err).NotTo(HaveOccurred())
+		return connection
+	}
+
+	AfterEach(func() {
+		assertClose(streamingAdapter)
+		assertClose(grpcConnection)
+	})
+
+	It("rejects upgrade requests from foreign origins", func() {
+		start(NewSplitterServer())
+		config, err := websocket.NewConfig(fmt.Sprintf("ws://%s/", adapterAddress), "https://attacker.example")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = websocket.DialConfig(config)
+
+		Expect(err).To(MatchError(ContainSubstring("bad status")))
+		request, err := http.NewRequest("GET", fmt.Sprintf("http://%s/", adapterAddress), nil)
+		Expect(err).NotTo(HaveOccurred())
+		request.Header = http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}, "Origin": {"https://attacker.example"}}
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(403))
+		Expect(asString(response.Body)).To(Equal(`WebSocket origin not allowed: "https://attacker.example" is not allowed`))
+	})
+
+	It("accepts upgrade requests from the allowed origins", func() {
+		start(NewSplitterServer(), "https://app.example.com")
+		config, err := websocket.NewConfig(fmt.Sprintf("ws://%s/", adapterAddress), "https://app.example.com")
+		Expect(err).NotTo(HaveOccurred())
+
+		connection, err := websocket.DialConfig(config)
+
+		Expect(err).NotTo(HaveOccurred())
+		defer assertClose(connection)
+		Expect(websocket.Message.Send(connection, "a")).To(Succeed())
+		Expect(receiveMessage(connection)).To(Equal("a\n"))
+	})
+
+	It("exchanges messages with the function, beyond the adapter timeout", func() {
+		start(NewSplitterServer())
+		connection := dial()
+		defer assertClose(connection)
+
+		Expect(websocket.Message.Send(connection, "a,b")).To(Succeed())
+		Expect(receiveMessage(connection)).To(Equal("a\n"))
+		Expect(receiveMessage(connection)).To(Equal("b\n"))
+		time.Sleep(300 * time.Millisecond)
+		Expect(websocket.Message.Send(connection, "c")).To(Succeed())
+		Expect(receiveMessage(connection)).To(Equal("c\n"))
+	})
+
+	It("derives the requested media type from the subprotocol", func() {
+		start(NewFrenchizerServer())
+		connection := dial("unknown", "json")
+		defer assertClose(connection)
+
+		Expect(connection.Config().Protocol).To(Equal([]string{"json"}))
+		Expect(websocket.Message.Send(connection, "2")).To(Succeed())
+		Expect(receiveMessage(connection)).To(Equal(`"deux"`))
+	})
+
+	It("closes the connection once the function is done", func() {
+		start(NewFrenchizerServer())
+		connection := dial("text")
+		defer assertClose(connection)
+
+		Expect(websocket.Message.Send(connection, "3")).To(Succeed())
+		Expect(receiveMessage(connection)).To(Equal("trois"))
+		var message string
+		Expect(websocket.Message.Receive(connection, &message)).To(MatchError(io.EOF))
+	})
+
+	It("keeps connections open while shutting down, until the grace period ends", func() {
+		start(NewSplitterServer())
+		connection := dial()
+		defer assertClose(connection)
+		Expect(websocket.Message.Send(connection, "a")).To(Succeed())
+		Expect(receiveMessage(connection)).To(Equal("a\n"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		result := make(chan error, 1)
+		go func() {
+			result <- streamingAdapter.Shutdown(ctx)
+		}()
+
+		Expect(websocket.Message.Send(connection, "b")).To(Succeed())
+		Expect(receiveMessage(connection)).To(Equal("b\n"))
+		Eventually(result, time.Second).Should(Receive(Equal(context.DeadlineExceeded)))
+		var message string
+		Expect(websocket.Message.Receive(connection, &message)).To(MatchError(io.EOF))
+	})
+})
+
+func receiveMessage(connection *websocket.Conn) (string, error) {
+	var message string
+	err := websocket.Message.Receive(connection, &message)
+	return message, err
+}
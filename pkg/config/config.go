@@ -0,0 +1,435 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"riff-streaming-adapter/pkg/adapter"
+	"strings"
+	"time"
+)
+
+// Supported values of Routing.Resolver
+const (
+	// dials the address set in the X-Riff header
+	PassthroughResolver = "passthrough"
+	// dials the Kubernetes service named SERVICE_NAME/NAMESPACE in the X-Riff header
+	KnativeResolver = "knative"
+	// dials the Kubernetes service whose name and namespace are found in the Host header, see Routing.Knative
+	KnativeHostResolver = "knative-host"
+	// dials the target of the most specific route matching the request, see Routing.Routes
+	RoutesResolver = "routes"
+	// dials the addresses registered for the function named in the X-Riff header, see Routing.Registry
+	RegistryResolver = "registry"
+	// balances invocations across the addresses, or the SRV targets, set in the X-Riff header, see Routing.Balancing
+	BalancingResolver = "balancing"
+)
+
+var resolvers = []string{PassthroughResolver, KnativeResolver, KnativeHostResolver, RoutesResolver, RegistryResolver, BalancingResolver}
+
+// Settings of the streaming adapter, see Load for their sources
+type Config struct {
+	Listeners Listeners `json:"listeners"`
+	Routing   Routing   `json:"routing"`
+	Timeouts  Timeouts  `json:"timeouts"`
+	Limits    Limits    `json:"limits"`
+	Tls       Tls       `json:"tls"`
+	Framing   Framing   `json:"framing"`
+	Health    Health    `json:"health"`
+	Logging   Logging   `json:"logging"`
+	Tracing   Tracing   `json:"tracing"`
+}
+
+type Listeners struct {
+	// (mandatory) port serving the invocations
+	HttpPort int `json:"httpPort"`
+	// port exclusively serving the health and metrics endpoints, the HTTP port serves them otherwise
+	ManagementPort int `json:"managementPort"`
+	// prefix of the health and metrics endpoint paths, e.g. "/_riff"
+	ManagementPathPrefix string `json:"managementPathPrefix"`
+	// origins, e.g. "https://app.example.com", allowed to open WebSocket connections besides the adapter host, "*" for any
+	WebSocketOrigins []string `json:"webSocketOrigins"`
+}
+
+// How invocations reach the gRPC server of their function
+type Routing struct {
+	// one of PassthroughResolver (the default), KnativeResolver, KnativeHostResolver, RoutesResolver, RegistryResolver
+	// and BalancingResolver
+	Resolver string `json:"resolver"`
+	// settings of KnativeResolver and KnativeHostResolver
+	Knative Knative `json:"knative"`
+	// routing table of RoutesResolver
+	Routes []Route `json:"routes"`
+	// settings of RegistryResolver
+	Registry Registry `json:"registry"`
+	// settings of BalancingResolver
+	Balancing Balancing `json:"balancing"`
+	// targets the resolver may or may not reach, whatever the resolver
+	Policy Policy `json:"policy"`
+}
+
+type Knative struct {
+	// Go template of the function hosts, see adapter.DefaultDomainTemplate
+	DomainTemplate string `json:"domainTemplate"`
+	// domain of the function hosts, e.g. "example.com", any domain if empty
+	Domain string `json:"domain"`
+	// domain of the Kubernetes cluster, defaults to "cluster.local"
+	ClusterDomain string `json:"clusterDomain"`
+}
+
+// See adapter.RegistryResolver
+type Registry struct {
+	// YAML or JSON registry of the function addresses
+	File string `json:"file"`
+	// period of the checks for changes of the file, defaults to 1s
+	ReloadInterval Duration `json:"reloadInterval"`
+}
+
+// See adapter.BalancingResolver
+type Balancing struct {
+	// one of round-robin (the default), least-outstanding and power-of-two-choices
+	Strategy string `json:"strategy"`
+	// how long SRV records are cached, defaults to 30s
+	SrvTtl Duration `json:"srvTtl"`
+	// domain SRV names must belong to, defaults to Knative.ClusterDomain
+	SrvDomain string `json:"srvDomain"`
+}
+
+// See adapter.Route
+type Route struct {
+	Hosts      []string `json:"hosts"`
+	PathPrefix string   `json:"pathPrefix"`
+	Methods    []string `json:"methods"`
+	// address of the gRPC server, e.g. "orders.default.svc.cluster.local:8081"
+	Target    string `json:"target"`
+	Authority string `json:"authority"`
+	// labels the metrics of the requests the route matches, defaults to the target
+	Function string `json:"function"`
+}
+
+func (routing Routing) AdapterRoutes() []adapter.Route {
+	routes := make([]adapter.Route, len(routing.Routes))
+	for i, route := range routing.Routes {
+		routes[i] = adapter.Route{
+			Hosts:      route.Hosts,
+			PathPrefix: route.PathPrefix,
+			Methods:    route.Methods,
+			Target:     adapter.Target{Address: route.Target, Authority: route.Authority},
+			Function:   route.Function,
+		}
+	}
+	return routes
+}
+
+// See adapter.PolicyResolver. Any target is allowed when there are no rules.
+type Policy struct {
+	Allow []PolicyRule `json:"allow"`
+	Deny  []PolicyRule `json:"deny"`
+}
+
+// See adapter.PolicyRule
+type PolicyRule struct {
+	Namespaces []string `json:"namespaces"`
+	Services   []string `json:"services"`
+	// CIDR ranges, e.g. "10.0.0.0/8"
+	Networks []string `json:"networks"`
+	Ports    []int    `json:"ports"`
+}
+
+func (policy Policy) Enabled() bool {
+	return len(policy.Allow) > 0 || len(policy.Deny) > 0
+}
+
+// Wraps resolver with the policy, if enabled. Kubernetes service targets are named after the given cluster domain.
+func (policy Policy) Apply(resolver adapter.TargetResolver, clusterDomain string) (adapter.TargetResolver, error) {
+	if !policy.Enabled() {
+		return resolver, nil
+	}
+	policyResolver, err := adapter.NewPolicyResolver(resolver, adapterRules(policy.Allow), adapterRules(policy.Deny))
+	if err != nil {
+		return nil, err
+	}
+	policyResolver.ClusterDomain = clusterDomain
+	return policyResolver, nil
+}
+
+func adapterRules(rules []PolicyRule) []adapter.PolicyRule {
+	result := make([]adapter.PolicyRule, len(rules))
+	for i, rule := range rules {
+		result[i] = adapter.PolicyRule(rule)
+	}
+	return result
+}
+
+type Timeouts struct {
+	// (mandatory) maximum duration of an invocation
+	Invocation Duration `json:"invocation"`
+	// maximum duration of server-sent event and WebSocket streams, unlimited when zero
+	Stream Duration `json:"stream"`
+	// interval between two server-sent event heartbeats
+	SseHeartbeat Duration `json:"sseHeartbeat"`
+	// duration after which unused connections to functions are closed
+	PoolIdle Duration `json:"poolIdle"`
+	// duration in-flight invocations are given to complete upon shutdown
+	ShutdownGracePeriod Duration `json:"shutdownGracePeriod"`
+}
+
+// Limits are disabled when zero
+type Limits struct {
+	MaxRequestBodyBytes int64 `json:"maxRequestBodyBytes"`
+	MaxHeaderBytes      int   `json:"maxHeaderBytes"`
+	// maximum size of the frames extracted by the delimiter framing, and of the newline-delimited JSON request lines
+	MaxFrameBytes int `json:"maxFrameBytes"`
+}
+
+// The HTTP port is served over TLS when a certificate is set
+type Tls struct {
+	// PEM-encoded certificate chain
+	CertFile string `json:"certFile"`
+	// PEM-encoded private key of the certificate
+	KeyFile string `json:"keyFile"`
+	// PEM-encoded authorities clients must present a certificate of, if set
+	ClientCaFile string `json:"clientCaFile"`
+}
+
+// How request bodies are split into frames, a single frame is sent by default
+type Framing struct {
+	// maximum size of the frames, takes precedence over Delimiter
+	ChunkSize int `json:"chunkSize"`
+	// separator of the frames
+	Delimiter string `json:"delimiter"`
+}
+
+type Health struct {
+	// addresses of the gRPC servers that must be serving for the adapter to be ready
+	ReadinessTargets []string `json:"readinessTargets"`
+}
+
+type Logging struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// Invocations are traced when an endpoint is set
+type Tracing struct {
+	// URL of the OpenTelemetry collector receiving spans with the OTLP/HTTP JSON protocol
+	Endpoint    string `json:"endpoint"`
+	ServiceName string `json:"serviceName"`
+}
+
+// Duration written as a string such as "1m30s"
+type Duration time.Duration
+
+func (duration Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(duration).String())
+}
+
+func (duration *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("%s is invalid: expected a duration such as \"30s\"", data)
+	}
+	parsedDuration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%q is invalid: expected a duration such as \"30s\"", value)
+	}
+	*duration = Duration(parsedDuration)
+	return nil
+}
+
+func Default() *Config {
+	return &Config{
+		Routing: Routing{Resolver: PassthroughResolver, Balancing: Balancing{Strategy: string(adapter.RoundRobin)}},
+		Timeouts: Timeouts{
+			Stream:              Duration(time.Hour),
+			SseHeartbeat:        Duration(15 * time.Second),
+			PoolIdle:            Duration(5 * time.Minute),
+			ShutdownGracePeriod: Duration(30 * time.Second),
+		},
+		Logging: Logging{Level: adapter.InfoLevel.String(), Format: string(adapter.JsonFormat)},
+	}
+}
+
+// Problems found in a configuration, reported all at once
+type Errors []error
+
+func (errors Errors) Error() string {
+	messages := make([]string, len(errors))
+	for i, err := range errors {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Returns the problems of the configuration, if any, as Errors
+func (config *Config) Validate() error {
+	var errors Errors
+	invalid := func(field string, format string, args ...interface{}) {
+		errors = append(errors, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	missing := func(field string) {
+		errors = append(errors, fmt.Errorf("%s is missing", field))
+	}
+
+	if config.Listeners.HttpPort == 0 {
+		missing("listeners.httpPort")
+	} else if !isPort(config.Listeners.HttpPort) {
+		invalid("listeners.httpPort", "%d is invalid: expected a port between 1 and 65535", config.Listeners.HttpPort)
+	}
+	if config.Listeners.ManagementPort != 0 && !isPort(config.Listeners.ManagementPort) {
+		invalid("listeners.managementPort", "%d is invalid: expected a port between 1 and 65535", config.Listeners.ManagementPort)
+	} else if config.Listeners.ManagementPort != 0 && config.Listeners.ManagementPort == config.Listeners.HttpPort {
+		invalid("listeners.managementPort", "%d is invalid: expected a port other than the HTTP one", config.Listeners.ManagementPort)
+	}
+	if prefix := config.Listeners.ManagementPathPrefix; prefix != "" && !strings.HasPrefix(prefix, "/") {
+		invalid("listeners.managementPathPrefix", "%q is invalid: expected a path starting with /", prefix)
+	}
+	for _, origin := range config.Listeners.WebSocketOrigins {
+		if parsedOrigin, err := url.Parse(origin); origin != "*" && (err != nil || parsedOrigin.Scheme == "" || parsedOrigin.Host == "") {
+			invalid("listeners.webSocketOrigins", "%q is invalid: expected an origin such as https://app.example.com, or *", origin)
+		}
+	}
+
+	if !contains(resolvers, config.Routing.Resolver) {
+		invalid("routing.resolver", "%q is invalid: expected one of %s", config.Routing.Resolver, strings.Join(resolvers, ", "))
+	}
+	if config.Routing.Resolver == KnativeHostResolver {
+		knative := config.Routing.Knative
+		if _, err := adapter.NewKnativeHostResolver(knative.DomainTemplate, knative.Domain, knative.ClusterDomain); err != nil {
+			invalid("routing.knative.domainTemplate", "%v", err)
+		}
+	}
+	if config.Routing.Resolver == RoutesResolver {
+		if len(config.Routing.Routes) == 0 {
+			missing("routing.routes")
+		} else if _, err := adapter.NewRoutingResolver(config.Routing.AdapterRoutes()); err != nil {
+			invalid("routing.routes", "%v", err)
+		}
+	} else if len(config.Routing.Routes) > 0 {
+		invalid("routing.routes", "expected routing.resolver to be %s", RoutesResolver)
+	}
+	if config.Routing.Resolver == RegistryResolver {
+		if config.Routing.Registry.File == "" {
+			missing("routing.registry.file")
+		} else if resolver, err := adapter.NewRegistryResolver(config.Routing.Registry.File, 0); err != nil {
+			invalid("routing.registry.file", "%v", err)
+		} else {
+			_ = resolver.Close()
+		}
+	}
+	if _, err := adapter.ParseBalancingStrategy(config.Routing.Balancing.Strategy); err != nil {
+		invalid("routing.balancing.strategy", "%v", err)
+	}
+	if _, err := config.Routing.Policy.Apply(&adapter.PassthroughResolver{}, config.Routing.Knative.ClusterDomain); err != nil {
+		invalid("routing.policy", "%v", err)
+	}
+
+	if config.Timeouts.Invocation == 0 {
+		missing("timeouts.invocation")
+	}
+	durations := []struct {
+		field string
+		value Duration
+	}{
+		{"timeouts.invocation", config.Timeouts.Invocation},
+		{"timeouts.stream", config.Timeouts.Stream},
+		{"timeouts.sseHeartbeat", config.Timeouts.SseHeartbeat},
+		{"timeouts.poolIdle", config.Timeouts.PoolIdle},
+		{"timeouts.shutdownGracePeriod", config.Timeouts.ShutdownGracePeriod},
+		{"routing.registry.reloadInterval", config.Routing.Registry.ReloadInterval},
+		{"routing.balancing.srvTtl", config.Routing.Balancing.SrvTtl},
+	}
+	for _, duration := range durations {
+		if duration.value < 0 {
+			invalid(duration.field, "%s is invalid: expected a positive duration", time.Duration(duration.value))
+		}
+	}
+
+	sizes := []struct {
+		field string
+		value int64
+	}{
+		{"limits.maxRequestBodyBytes", config.Limits.MaxRequestBodyBytes},
+		{"limits.maxHeaderBytes", int64(config.Limits.MaxHeaderBytes)},
+		{"limits.maxFrameBytes", int64(config.Limits.MaxFrameBytes)},
+		{"framing.chunkSize", int64(config.Framing.ChunkSize)},
+	}
+	for _, size := range sizes {
+		if size.value < 0 {
+			invalid(size.field, "%d is invalid: expected a positive number of bytes", size.value)
+		}
+	}
+
+	if (config.Tls.CertFile == "") != (config.Tls.KeyFile == "") {
+		invalid("tls", "expected both certFile and keyFile to be set, or neither")
+	} else if config.Tls.CertFile == "" && config.Tls.ClientCaFile != "" {
+		invalid("tls.clientCaFile", "expected certFile and keyFile to be set as well")
+	} else if _, err := config.Tls.Load(); err != nil {
+		invalid("tls", "%v", err)
+	}
+
+	for _, address := range config.Health.ReadinessTargets {
+		if strings.TrimSpace(address) == "" {
+			invalid("health.readinessTargets", "expected non-blank addresses")
+			break
+		}
+	}
+
+	if _, err := adapter.ParseLevel(config.Logging.Level); err != nil {
+		invalid("logging.level", "%v", err)
+	}
+	if _, err := adapter.ParseLogFormat(config.Logging.Format); err != nil {
+		invalid("logging.format", "%v", err)
+	}
+
+	if endpoint := config.Tracing.Endpoint; endpoint != "" {
+		if parsedEndpoint, err := url.Parse(endpoint); err != nil || (parsedEndpoint.Scheme != "http" && parsedEndpoint.Scheme != "https") {
+			invalid("tracing.endpoint", "%q is invalid: expected an http or https URL", endpoint)
+		}
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+// Returns nil when no certificate is set. Clients must present a certificate when client authorities are set.
+func (config Tls) Load() (*tls.Config, error) {
+	if config.CertFile == "" {
+		return nil, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	result := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	if config.ClientCaFile != "" {
+		authorities, err := ioutil.ReadFile(config.ClientCaFile)
+		if err != nil {
+			return nil, err
+		}
+		result.ClientCAs = x509.NewCertPool()
+		if !result.ClientCAs.AppendCertsFromPEM(authorities) {
+			return nil, fmt.Errorf("%s contains no PEM-encoded certificate", config.ClientCaFile)
+		}
+		result.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return result, nil
+}
+
+func isPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
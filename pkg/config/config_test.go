@@ -0,0 +1,338 @@
+package config_test
+
+import (
+	"bytes"
+	"flag"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"riff-streaming-adapter/pkg/adapter"
+	"riff-streaming-adapter/pkg/config"
+	"time"
+)
+
+var _ = Describe("Config", func() {
+
+	var (
+		env       map[string]string
+		output    *bytes.Buffer
+		directory string
+	)
+
+	BeforeEach(func() {
+		env = make(map[string]string)
+		output = &bytes.Buffer{}
+		var err error
+		directory, err = ioutil.TempDir("", "config")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(directory)).To(Succeed())
+	})
+
+	lookupEnv := func(name string) (string, bool) {
+		value, found := env[name]
+		return value, found
+	}
+
+	load := func(arguments ...string) (*config.Config, error) {
+		return config.Load(arguments, lookupEnv, output)
+	}
+
+	writeFile := func(name string, content string) string {
+		path := filepath.Join(directory, name)
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return path
+	}
+
+	It("reads YAML files", func() {
+		path := writeFile("config.yaml", `
+listeners:
+  httpPort: 8080
+  managementPort: 9090
+  managementPathPrefix: /_riff
+  webSocketOrigins: [https://app.example.com]
+routing:
+  resolver: knative
+timeouts:
+  invocation: 30s
+  poolIdle: 1m
+limits:
+  maxRequestBodyBytes: 1024
+framing:
+  delimiter: "\n"
+health:
+  readinessTargets: [localhost:8081]
+logging:
+  level: debug
+  format: logfmt
+`)
+
+		configuration, err := load("-config", path)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configuration.Listeners).To(Equal(config.Listeners{HttpPort: 8080, ManagementPort: 9090, ManagementPathPrefix: "/_riff", WebSocketOrigins: []string{"https://app.example.com"}}))
+		Expect(configuration.Routing.Resolver).To(Equal(config.KnativeResolver))
+		Expect(configuration.Timeouts).To(Equal(config.Timeouts{
+			Invocation:          config.Duration(30 * time.Second),
+			Stream:              config.Duration(time.Hour),
+			SseHeartbeat:        config.Duration(15 * time.Second),
+			PoolIdle:            config.Duration(time.Minute),
+			ShutdownGracePeriod: config.Duration(30 * time.Second),
+		}))
+		Expect(configuration.Limits.MaxRequestBodyBytes).To(Equal(int64(1024)))
+		Expect(configuration.Framing.Delimiter).To(Equal("\n"))
+		Expect(configuration.Health.ReadinessTargets).To(Equal([]string{"localhost:8081"}))
+		Expect(configuration.Logging).To(Equal(config.Logging{Level: "debug", Format: "logfmt"}))
+	})
+
+	It("reads JSON files named by the CONFIG_FILE envvar", func() {
+		env["CONFIG_FILE"] = writeFile("config.json", `{"listeners": {"httpPort": 8080}, "timeouts": {"invocation": "1s"}}`)
+
+		configuration, err := load()
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configuration.Listeners.HttpPort).To(Equal(8080))
+		Expect(configuration.Timeouts.Invocation).To(Equal(config.Duration(time.Second)))
+	})
+
+	It("overrides files with envvars, and envvars with flags", func() {
+		path := writeFile("config.yaml", "listeners: {httpPort: 8080, managementPort: 9090}\ntimeouts: {invocation: 1s}\nlogging: {level: warn}")
+		env["HTTP_PORT"] = "8081"
+		env["HTTP_TIMEOUT_MILLISECONDS"] = "2000"
+		env["LOG_LEVEL"] = "error"
+		env["OTEL_EXPORTER_OTLP_ENDPOINT"] = "http://localhost:4318/"
+
+		configuration, err := load("-config", path, "-http-port", "8082", "-log-level", "debug")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configuration.Listeners.HttpPort).To(Equal(8082))
+		Expect(configuration.Listeners.ManagementPort).To(Equal(9090))
+		Expect(configuration.Timeouts.Invocation).To(Equal(config.Duration(2 * time.Second)))
+		Expect(configuration.Logging.Level).To(Equal("debug"))
+		Expect(configuration.Tracing.Endpoint).To(Equal("http://localhost:4318/v1/traces"))
+	})
+
+	It("reports all the problems at once", func() {
+		path := writeFile("config.yaml", "listeners: {managementPort: 70000, managementPathPrefix: _riff}\nrouting: {resolver: dns}\nlimits: {maxRequestBodyBytes: -1}\ntls: {certFile: cert.pem}")
+		env["HTTP_TIMEOUT_MILLISECONDS"] = "soon"
+
+		_, err := load("-config", path, "-log-format", "xml")
+
+		Expect(err).To(BeAssignableToTypeOf(config.Errors{}))
+		Expect(err.Error()).To(Equal(`envvar HTTP_TIMEOUT_MILLISECONDS: "soon" is invalid: expected a number of milliseconds
+listeners.httpPort is missing
+listeners.managementPort: 70000 is invalid: expected a port between 1 and 65535
+listeners.managementPathPrefix: "_riff" is invalid: expected a path starting with /
+routing.resolver: "dns" is invalid: expected one of passthrough, knative, knative-host, routes, registry, balancing
+timeouts.invocation is missing
+limits.maxRequestBodyBytes: -1 is invalid: expected a positive number of bytes
+tls: expected both certFile and keyFile to be set, or neither
+logging.format: "xml" is invalid: expected format to be one of json, logfmt`))
+	})
+
+	It("reads Knative settings", func() {
+		env["HTTP_PORT"] = "8080"
+		env["HTTP_TIMEOUT_MILLISECONDS"] = "1000"
+		env["RESOLVER"] = "knative-host"
+		env["KNATIVE_DOMAIN_TEMPLATE"] = "{{.Name}}-{{.Namespace}}.{{.Domain}}"
+		env["KNATIVE_DOMAIN"] = "example.com"
+		env["CLUSTER_DOMAIN"] = "cluster.example"
+
+		configuration, err := load()
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configuration.Routing.Knative).To(Equal(config.Knative{
+			DomainTemplate: "{{.Name}}-{{.Namespace}}.{{.Domain}}",
+			Domain:         "example.com",
+			ClusterDomain:  "cluster.example",
+		}))
+	})
+
+	It("reports invalid domain templates", func() {
+		env["HTTP_PORT"] = "8080"
+		env["HTTP_TIMEOUT_MILLISECONDS"] = "1000"
+		env["RESOLVER"] = "knative-host"
+		env["KNATIVE_DOMAIN_TEMPLATE"] = "{{.Name}}.{{.Domain}}"
+
+		_, err := load()
+
+		Expect(err).To(MatchError(`routing.knative.domainTemplate: "{{.Name}}.{{.Domain}}" is invalid: expected the template to reference both {{.Name}} and {{.Namespace}}`))
+	})
+
+	It("reads routing tables", func() {
+		path := writeFile("config.yaml", `
+listeners: {httpPort: 8080}
+timeouts: {invocation: 1s}
+routing:
+  resolver: routes
+  routes:
+    - hosts: ["*.example.com"]
+      pathPrefix: /orders
+      methods: [POST]
+      target: orders.default.svc.cluster.local:8081
+      authority: orders.example.com
+      function: orders
+`)
+
+		configuration, err := load("-config", path)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configuration.Routing.AdapterRoutes()).To(Equal([]adapter.Route{{
+			Hosts:      []string{"*.example.com"},
+			PathPrefix: "/orders",
+			Methods:    []string{"POST"},
+			Target:     adapter.Target{Address: "orders.default.svc.cluster.local:8081", Authority: "orders.example.com"},
+			Function:   "orders",
+		}}))
+	})
+
+	It("reports invalid routing tables", func() {
+		path := writeFile("config.yaml", "listeners: {httpPort: 8080}\ntimeouts: {invocation: 1s}\nrouting: {resolver: routes, routes: [{pathPrefix: /orders}]}")
+
+		_, err := load("-config", path)
+
+		Expect(err).To(MatchError("routing.routes: route 0: target address is missing"))
+	})
+
+	It("reports routing tables of other resolvers", func() {
+		path := writeFile("config.yaml", "listeners: {httpPort: 8080}\ntimeouts: {invocation: 1s}\nrouting: {routes: [{target: localhost:8081}]}")
+
+		_, err := load("-config", path)
+
+		Expect(err).To(MatchError("routing.routes: expected routing.resolver to be routes"))
+	})
+
+	It("reads service registries", func() {
+		env["HTTP_PORT"] = "8080"
+		env["HTTP_TIMEOUT_MILLISECONDS"] = "1000"
+		env["RESOLVER"] = "registry"
+		env["REGISTRY_FILE"] = writeFile("registry.yaml", "functions: {square: {addresses: [localhost:8081]}}")
+
+		configuration, err := load()
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configuration.Routing.Registry).To(Equal(config.Registry{File: env["REGISTRY_FILE"]}))
+	})
+
+	It("reports invalid service registries", func() {
+		env["HTTP_PORT"] = "8080"
+		env["HTTP_TIMEOUT_MILLISECONDS"] = "1000"
+		env["RESOLVER"] = "registry"
+		env["REGISTRY_FILE"] = writeFile("registry.yaml", "functions: {square: {}}")
+
+		_, err := load()
+
+		Expect(err).To(MatchError(ContainSubstring(`routing.registry.file: ` + env["REGISTRY_FILE"] + `: function "square": addresses are missing`)))
+	})
+
+	It("reports invalid WebSocket origins", func() {
+		env["HTTP_PORT"] = "8080"
+		env["HTTP_TIMEOUT_MILLISECONDS"] = "1000"
+		env["WEBSOCKET_ORIGINS"] = "*, app.example.com"
+
+		_, err := load()
+
+		Expect(err).To(MatchError(`listeners.webSocketOrigins: "app.example.com" is invalid: expected an origin such as https://app.example.com, or *`))
+	})
+
+	It("reads balancing settings", func() {
+		path := writeFile("config.yaml", "listeners: {httpPort: 8080}\ntimeouts: {invocation: 1s}\nrouting: {resolver: balancing, balancing: {srvTtl: 10s, srvDomain: example.org}}")
+		env["BALANCING_STRATEGY"] = "least-outstanding"
+
+		configuration, err := load("-config", path)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configuration.Routing.Balancing).To(Equal(config.Balancing{Strategy: "least-outstanding", SrvTtl: config.Duration(10 * time.Second), SrvDomain: "example.org"}))
+	})
+
+	It("reads the balancing strategy from the -balancing-strategy flag", func() {
+		env["BALANCING_STRATEGY"] = "least-outstanding"
+
+		configuration, err := load("-http-port", "8080", "-timeout", "1s", "-resolver", "balancing", "-balancing-strategy", "power-of-two-choices")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configuration.Routing.Resolver).To(Equal("balancing"))
+		Expect(configuration.Routing.Balancing.Strategy).To(Equal("power-of-two-choices"))
+	})
+
+	It("reports unknown balancing strategies", func() {
+		env["HTTP_PORT"] = "8080"
+		env["HTTP_TIMEOUT_MILLISECONDS"] = "1000"
+		env["BALANCING_STRATEGY"] = "random"
+
+		_, err := load()
+
+		Expect(err).To(MatchError(`routing.balancing.strategy: "random" is invalid: expected strategy to be one of round-robin, least-outstanding, power-of-two-choices`))
+	})
+
+	It("reads resolver policies", func() {
+		path := writeFile("config.yaml", `
+listeners: {httpPort: 8080}
+timeouts: {invocation: 1s}
+routing:
+  policy:
+    allow:
+      - namespaces: [team-*]
+        ports: [8080]
+    deny:
+      - networks: [169.254.0.0/16]
+`)
+
+		configuration, err := load("-config", path)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configuration.Routing.Policy).To(Equal(config.Policy{
+			Allow: []config.PolicyRule{{Namespaces: []string{"team-*"}, Ports: []int{8080}}},
+			Deny:  []config.PolicyRule{{Networks: []string{"169.254.0.0/16"}}},
+		}))
+		resolver, err := configuration.Routing.Policy.Apply(&adapter.PassthroughResolver{}, "example.org")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolver).To(BeAssignableToTypeOf(&adapter.PolicyResolver{}))
+		Expect(resolver.(*adapter.PolicyResolver).ClusterDomain).To(Equal("example.org"))
+	})
+
+	It("reports invalid resolver policies", func() {
+		path := writeFile("config.yaml", "listeners: {httpPort: 8080}\ntimeouts: {invocation: 1s}\nrouting: {policy: {deny: [{networks: [10.0.0.1]}]}}")
+
+		_, err := load("-config", path)
+
+		Expect(err).To(MatchError(`routing.policy: deny rule 0: "10.0.0.1" is invalid: expected a CIDR range such as 10.0.0.0/8`))
+	})
+
+	It("rejects unknown fields", func() {
+		path := writeFile("config.yaml", "listeners: {httpPort: 8080}\ntimeouts: {invocation: 1s}\nresolver: knative")
+
+		_, err := load("-config", path)
+
+		Expect(err).To(MatchError(ContainSubstring(`unknown field "resolver"`)))
+	})
+
+	It("rejects durations without unit", func() {
+		path := writeFile("config.yaml", "listeners: {httpPort: 8080}\ntimeouts: {invocation: 30}")
+
+		_, err := load("-config", path)
+
+		Expect(err).To(MatchError(ContainSubstring(`30 is invalid: expected a duration such as "30s"`)))
+	})
+
+	It("reports unreadable TLS certificates", func() {
+		env["HTTP_PORT"] = "8080"
+		env["HTTP_TIMEOUT_MILLISECONDS"] = "1000"
+
+		_, err := load("-tls-cert-file", "missing.pem", "-tls-key-file", "missing.key")
+
+		Expect(err).To(MatchError(ContainSubstring("tls: open missing.pem")))
+	})
+
+	It("prints its usage on demand", func() {
+		_, err := load("-h")
+
+		Expect(err).To(Equal(flag.ErrHelp))
+		Expect(output.String()).To(HavePrefix("Usage: riff-streaming-adapter [validate] [flags]"))
+		Expect(output.String()).To(ContainSubstring("-http-port"))
+	})
+})
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Envvar and flag naming the configuration file
+const (
+	FileEnvVar = "CONFIG_FILE"
+	FileFlag   = "config"
+)
+
+// Setting overridable by an environment variable or a command-line flag
+type override struct {
+	name  string
+	usage string
+	set   func(config *Config, value string) error
+}
+
+var envOverrides = []override{
+	{name: "HTTP_PORT", set: intSetting(func(config *Config) *int { return &config.Listeners.HttpPort })},
+	{name: "MANAGEMENT_PORT", set: intSetting(func(config *Config) *int { return &config.Listeners.ManagementPort })},
+	{name: "MANAGEMENT_PATH_PREFIX", set: stringSetting(func(config *Config) *string { return &config.Listeners.ManagementPathPrefix })},
+	{name: "WEBSOCKET_ORIGINS", set: listSetting(func(config *Config) *[]string { return &config.Listeners.WebSocketOrigins })},
+	{name: "RESOLVER", set: stringSetting(func(config *Config) *string { return &config.Routing.Resolver })},
+	{name: "KNATIVE_DOMAIN_TEMPLATE", set: stringSetting(func(config *Config) *string { return &config.Routing.Knative.DomainTemplate })},
+	{name: "KNATIVE_DOMAIN", set: stringSetting(func(config *Config) *string { return &config.Routing.Knative.Domain })},
+	{name: "CLUSTER_DOMAIN", set: stringSetting(func(config *Config) *string { return &config.Routing.Knative.ClusterDomain })},
+	{name: "BALANCING_STRATEGY", set: stringSetting(func(config *Config) *string { return &config.Routing.Balancing.Strategy })},
+	{name: "REGISTRY_FILE", set: stringSetting(func(config *Config) *string { return &config.Routing.Registry.File })},
+	{name: "HTTP_TIMEOUT_MILLISECONDS", set: millisecondsSetting(func(config *Config) *Duration { return &config.Timeouts.Invocation })},
+	{name: "STREAM_TIMEOUT_MILLISECONDS", set: millisecondsSetting(func(config *Config) *Duration { return &config.Timeouts.Stream })},
+	{name: "SSE_HEARTBEAT_MILLISECONDS", set: millisecondsSetting(func(config *Config) *Duration { return &config.Timeouts.SseHeartbeat })},
+	{name: "POOL_IDLE_TIMEOUT_MILLISECONDS", set: millisecondsSetting(func(config *Config) *Duration { return &config.Timeouts.PoolIdle })},
+	{name: "SHUTDOWN_GRACE_PERIOD_MILLISECONDS", set: millisecondsSetting(func(config *Config) *Duration { return &config.Timeouts.ShutdownGracePeriod })},
+	{name: "MAX_REQUEST_BODY_BYTES", set: int64Setting(func(config *Config) *int64 { return &config.Limits.MaxRequestBodyBytes })},
+	{name: "MAX_HEADER_BYTES", set: intSetting(func(config *Config) *int { return &config.Limits.MaxHeaderBytes })},
+	{name: "MAX_FRAME_BYTES", set: intSetting(func(config *Config) *int { return &config.Limits.MaxFrameBytes })},
+	{name: "TLS_CERT_FILE", set: stringSetting(func(config *Config) *string { return &config.Tls.CertFile })},
+	{name: "TLS_KEY_FILE", set: stringSetting(func(config *Config) *string { return &config.Tls.KeyFile })},
+	{name: "TLS_CLIENT_CA_FILE", set: stringSetting(func(config *Config) *string { return &config.Tls.ClientCaFile })},
+	{name: "HTTP_REQUEST_CHUNK_SIZE", set: intSetting(func(config *Config) *int { return &config.Framing.ChunkSize })},
+	{name: "HTTP_REQUEST_DELIMITER", set: stringSetting(func(config *Config) *string { return &config.Framing.Delimiter })},
+	{name: "READINESS_TARGETS", set: listSetting(func(config *Config) *[]string { return &config.Health.ReadinessTargets })},
+	{name: "LOG_LEVEL", set: stringSetting(func(config *Config) *string { return &config.Logging.Level })},
+	{name: "LOG_FORMAT", set: stringSetting(func(config *Config) *string { return &config.Logging.Format })},
+	{name: "OTEL_EXPORTER_OTLP_ENDPOINT", set: func(config *Config, value string) error {
+		config.Tracing.Endpoint = strings.TrimSuffix(value, "/") + "/v1/traces"
+		return nil
+	}},
+	// takes precedence over OTEL_EXPORTER_OTLP_ENDPOINT
+	{name: "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", set: stringSetting(func(config *Config) *string { return &config.Tracing.Endpoint })},
+	{name: "OTEL_SERVICE_NAME", set: stringSetting(func(config *Config) *string { return &config.Tracing.ServiceName })},
+}
+
+var flagOverrides = []override{
+	{name: "http-port", usage: "port serving the invocations", set: intSetting(func(config *Config) *int { return &config.Listeners.HttpPort })},
+	{name: "management-port", usage: "port exclusively serving the health and metrics endpoints", set: intSetting(func(config *Config) *int { return &config.Listeners.ManagementPort })},
+	{name: "resolver", usage: "how invocations reach functions: passthrough, knative, knative-host, routes, registry or balancing", set: stringSetting(func(config *Config) *string { return &config.Routing.Resolver })},
+	{name: "balancing-strategy", usage: "how the balancing resolver picks addresses: round-robin, least-outstanding or power-of-two-choices", set: stringSetting(func(config *Config) *string { return &config.Routing.Balancing.Strategy })},
+	{name: "timeout", usage: "maximum duration of an invocation, e.g. 30s", set: durationSetting(func(config *Config) *Duration { return &config.Timeouts.Invocation })},
+	{name: "max-request-body-bytes", usage: "maximum size of the request bodies", set: int64Setting(func(config *Config) *int64 { return &config.Limits.MaxRequestBodyBytes })},
+	{name: "tls-cert-file", usage: "certificate serving the HTTP port over TLS", set: stringSetting(func(config *Config) *string { return &config.Tls.CertFile })},
+	{name: "tls-key-file", usage: "private key of the TLS certificate", set: stringSetting(func(config *Config) *string { return &config.Tls.KeyFile })},
+	{name: "log-level", usage: "minimum level of the logged entries: debug, info, warn or error", set: stringSetting(func(config *Config) *string { return &config.Logging.Level })},
+	{name: "log-format", usage: "format of the logged entries: json or logfmt", set: stringSetting(func(config *Config) *string { return &config.Logging.Format })},
+}
+
+// Reads the configuration from, by increasing precedence: the defaults, the YAML or JSON file named by the -config flag
+// or the CONFIG_FILE envvar, environment variables and the given command-line flags.
+// All the problems found along the way, including validation ones, are returned at once as Errors.
+// flag.ErrHelp is returned as is when help is requested, after the usage is written to output.
+func Load(arguments []string, lookupEnv func(string) (string, bool), output io.Writer) (*Config, error) {
+	flagSet := flag.NewFlagSet("riff-streaming-adapter", flag.ContinueOnError)
+	flagSet.SetOutput(output)
+	flagSet.Usage = func() {
+		_, _ = fmt.Fprintln(output, "Usage: riff-streaming-adapter [validate] [flags]")
+		_, _ = fmt.Fprintln(output, "The validate command reports the configuration problems, if any, instead of running the adapter.")
+		flagSet.PrintDefaults()
+	}
+	file := flagSet.String(FileFlag, "", "YAML or JSON configuration file, overridden by envvars and flags")
+	for _, override := range flagOverrides {
+		flagSet.String(override.name, "", override.usage)
+	}
+	if err := flagSet.Parse(arguments); err != nil {
+		return nil, err
+	}
+	if flagSet.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flagSet.Args(), " "))
+	}
+
+	config := Default()
+	var errors Errors
+	if *file == "" {
+		*file, _ = lookupEnv(FileEnvVar)
+	}
+	if *file != "" {
+		if err := config.readFile(*file); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	for _, override := range envOverrides {
+		if value, found := lookupEnv(override.name); found {
+			if err := override.set(config, value); err != nil {
+				errors = append(errors, fmt.Errorf("envvar %s: %v", override.name, err))
+			}
+		}
+	}
+	flagSet.Visit(func(setFlag *flag.Flag) {
+		for _, override := range flagOverrides {
+			if override.name == setFlag.Name {
+				if err := override.set(config, setFlag.Value.String()); err != nil {
+					errors = append(errors, fmt.Errorf("flag -%s: %v", override.name, err))
+				}
+			}
+		}
+	})
+	if err := config.Validate(); err != nil {
+		errors = append(errors, err.(Errors)...)
+	}
+	if len(errors) > 0 {
+		return nil, errors
+	}
+	return config, nil
+}
+
+// YAML being a superset of JSON, both are decoded as YAML, then mapped to the configuration as JSON.
+// Unknown fields are rejected.
+func (config *Config) readFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var document interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("file %s: %v", path, err)
+	}
+	if document == nil {
+		return nil
+	}
+	jsonDocument, err := json.Marshal(jsonCompatible(document))
+	if err != nil {
+		return fmt.Errorf("file %s: %v", path, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonDocument))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("file %s: %v", path, err)
+	}
+	return nil
+}
+
+// YAML mappings are decoded with interface{} keys, which JSON does not support
+func jsonCompatible(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(typedValue))
+		for key, item := range typedValue {
+			result[fmt.Sprint(key)] = jsonCompatible(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(typedValue))
+		for i, item := range typedValue {
+			result[i] = jsonCompatible(item)
+		}
+		return result
+	}
+	return value
+}
+
+func stringSetting(field func(*Config) *string) func(*Config, string) error {
+	return func(config *Config, value string) error {
+		*field(config) = value
+		return nil
+	}
+}
+
+func intSetting(field func(*Config) *int) func(*Config, string) error {
+	return func(config *Config, value string) error {
+		parsedValue, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is invalid: expected an integer", value)
+		}
+		*field(config) = parsedValue
+		return nil
+	}
+}
+
+func int64Setting(field func(*Config) *int64) func(*Config, string) error {
+	return func(config *Config, value string) error {
+		parsedValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is invalid: expected an integer", value)
+		}
+		*field(config) = parsedValue
+		return nil
+	}
+}
+
+func millisecondsSetting(field func(*Config) *Duration) func(*Config, string) error {
+	return func(config *Config, value string) error {
+		milliseconds, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is invalid: expected a number of milliseconds", value)
+		}
+		*field(config) = Duration(time.Duration(milliseconds) * time.Millisecond)
+		return nil
+	}
+}
+
+func durationSetting(field func(*Config) *Duration) func(*Config, string) error {
+	return func(config *Config, value string) error {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is invalid: expected a duration such as \"30s\"", value)
+		}
+		*field(config) = Duration(duration)
+		return nil
+	}
+}
+
+// Comma-separated values, blank ones are ignored
+func listSetting(field func(*Config) *[]string) func(*Config, string) error {
+	return func(config *Config, value string) error {
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		*field(config) = values
+		return nil
+	}
+}